@@ -23,52 +23,443 @@
 package api
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/samitpal/influxdb-router/backends"
 	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/lifecycle"
 	"github.com/samitpal/influxdb-router/logging"
+	"github.com/samitpal/influxdb-router/ratelimit"
+	"github.com/samitpal/influxdb-router/stats"
+	"github.com/samitpal/influxdb-router/usage"
 )
 
-var log = logging.For("api")
+var (
+	log   = logging.For("api")
+	audit = logging.For("audit")
+)
 
 // HTTPListenerConfig holds configs for the http daemon
 type HTTPListenerConfig struct {
-	Addr     string
-	Port     string
-	TomlConf config.Configs
-	APIConf  config.APIKeyMap
+	Addr string
+	Port string
+	// Customers can be changed at runtime with /api/v1/customers.
+	Customers *config.Registry
+	// Ingress holds the incoming queues purged with /api/v1/customers/<name>/purge.
+	Ingress *backends.Ingress
+	// Prometheus is served on /metrics when not nil.
+	Prometheus *stats.Prometheus
+	// UsageStore is served on /api/v1/accounting, and on /api/v1/usage with from or to, when not nil.
+	UsageStore *usage.Store
+	// Journal is served on /api/v1/messages when not nil.
+	Journal *lifecycle.Journal
+
+	Secure            bool
+	SSLCAServerCert   string
+	SSLServerCert     string
+	SSLServerKey      string
+	SSLClientCertAuth bool
+	// Auth authenticates and authorizes every request when not nil.
+	Auth *Auth
+
+	TailMaxDuration time.Duration // longest tail of a customer
+	TailMaxRate     float64       // max lines per second of a tail
+
+	// Debug serves pprof on /debug/pprof, expvar on /debug/vars and the goroutines and the snapshot of
+	// the memory and the queues on /api/v1/debug.
+	Debug bool
 }
 
-// httpHandlers has all the routes defined.
-func httpHandlers(h *http.ServeMux, conf *HTTPListenerConfig) *http.ServeMux {
-	h.Handle("/api/v1/config", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { displayConfig(w, conf) }))
-	return h
+// mux is what the routes are added to, an *http.ServeMux but for the tests.
+type mux interface {
+	Handle(pattern string, handler http.Handler)
 }
 
-// HTTPListener exposes the http listener for api access (read only as of now).
-func HTTPListener(conf *HTTPListenerConfig) {
+// httpHandlers has all the routes defined, each with the role needed to read it. It returns the roles by pattern.
+func httpHandlers(h mux, conf *HTTPListenerConfig) map[string]role {
+	r := newRouter(h)
+	r.handle("/", readOnly, http.HandlerFunc(notFound))
+	r.handle("/api/v1/openapi.json", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { displayOpenAPI(w) }))
+	r.handle("/api/v1/config", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { displayConfig(w, req, conf) }))
+	if conf.Auth != nil {
+		r.handle("/api/v1/config/reveal", operatorOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { revealSecret(w, req, conf) }))
+	}
+	if conf.Prometheus != nil {
+		r.handle("/metrics", readOnly, conf.Prometheus)
+	}
+	r.handle("/api/v1/limits", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { displayLimits(w, conf) }))
+	r.handle("/api/v1/limits/", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { customerLimits(w, req, conf) }))
+	r.handle("/api/v1/backends", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { displayBackends(w, conf) }))
+	r.handle("/api/v1/backends/", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { backendControl(w, req, conf) }))
+	r.handle("/api/v1/customers", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { customers(w, req, conf) }))
+	r.handle("/api/v1/customers/", customerRole, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { customerResource(w, req, conf) }))
+	r.handle("/api/v1/usage", usageRole, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { displayUsage(w, req, conf) }))
+	r.handle("/api/v1/usage/", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { customerUsage(w, req, conf) }))
+	if conf.Journal != nil {
+		r.handle("/api/v1/messages", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { searchMessages(w, req, conf) }))
+		r.handle("/api/v1/messages/", readOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { messageTimeline(w, req, conf) }))
+	}
+	if conf.UsageStore != nil {
+		r.handle("/api/v1/accounting", operatorOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { accounting(w, req, conf) }))
+	}
+	if conf.Debug {
+		debugHandlers(r, conf)
+	}
+	return r.roles
+}
+
+// HTTPListener exposes the http listener for api access.
+func HTTPListener(conf *HTTPListenerConfig) *http.Server {
 	h := http.NewServeMux()
-	h = httpHandlers(h, conf)
+	roles := httpHandlers(h, conf)
+	srv := &http.Server{
+		Addr:    conf.Addr + ":" + conf.Port,
+		Handler: conf.Auth.Handler(h, roles),
+	}
+
+	//Run in https mode
+	if conf.Secure {
+		cfg := &tls.Config{}
+		if conf.SSLCAServerCert != "" {
+			caCert, err := ioutil.ReadFile(conf.SSLCAServerCert)
+			if err != nil {
+				log.Fatal(err)
+			}
+			caCertPool := x509.NewCertPool()
+			caCertPool.AppendCertsFromPEM(caCert)
+			cfg.ClientCAs = caCertPool
+			// clients can authenticate with a certificate instead of a token.
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		// if ssl client cert authentication is enabled.
+		if conf.SSLClientCertAuth {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		srv.TLSConfig = cfg
+		go func() {
+			log.Infof("InfluxDB Router https rest API service listening on %s:%s\n", conf.Addr, conf.Port)
+			err := srv.ListenAndServeTLS(conf.SSLServerCert, conf.SSLServerKey)
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("ListenAndServeTLS: %s\n", err)
+			}
+		}()
+		return srv
+	}
 
 	go func() {
 		log.Infof("InfluxDB Router http rest API service listening on %s:%s\n", conf.Addr, conf.Port)
-		err := http.ListenAndServe(conf.Addr+":"+conf.Port, h)
-		if err != nil {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("ListenAndServe: %s\n", err)
 		}
 	}()
+	return srv
+}
+
+// configView is the json representation of the config with the secrets masked.
+type configView struct {
+	Customers []config.CustomerView `json:"customers"`
+}
+
+// displayConfig shows the effective config with the secrets masked, and the emails too unless the client
+// is an operator.
+func displayConfig(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	configs, keys := conf.Customers.Snapshot()
+	views := configs.View(keys)
+	if !isOperator(req) {
+		for i := range views {
+			views[i].Email = config.Redact(views[i].Email)
+		}
+	}
+	writeJSON(w, http.StatusOK, configView{Customers: views})
+}
+
+// revealSecret shows a single secret of a customer in clear text to an operator. A reason is required
+// and every attempt is written to the audit log.
+func revealSecret(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+		return
+	}
+	q := req.URL.Query()
+	customer, secret, reason := q.Get("customer"), q.Get("secret"), q.Get("reason")
+	l := audit.WithFields(logrus.Fields{
+		"principal":   principalFromRequest(req),
+		"remote_addr": req.RemoteAddr,
+		"customer":    customer,
+		"secret":      secret,
+		"reason":      reason,
+	})
+
+	if reason == "" {
+		l.Warn("Denied revealing a secret: no reason")
+		writeError(w, http.StatusBadRequest, "A reason is required")
+		return
+	}
+	configs, keys := conf.Customers.Snapshot()
+	v, ok := configs.Secret(keys, customer, secret)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Secret %s of %s not found", secret, customer)
+		return
+	}
+	l.Warn("Revealed a secret")
+	writeJSON(w, http.StatusOK, map[string]string{"customer": customer, "secret": secret, "value": v})
+}
+
+// findCustomer returns the config of the customer with the given name.
+func findCustomer(ac config.APIKeyMap, name string) (config.APIKeyConfig, bool) {
+	for _, c := range ac {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return config.APIKeyConfig{}, false
+}
+
+// customerLimit is the json representation of the write limits and usage of a customer.
+type customerLimit struct {
+	Limits ratelimit.WriteLimits `json:"limits"`
+	Usage  ratelimit.WriteUsage  `json:"usage"`
+}
+
+func newCustomerLimit(c config.APIKeyConfig) customerLimit {
+	return customerLimit{Limits: c.WriteLimiter.Limits(), Usage: c.WriteLimiter.Usage()}
+}
+
+// apiError is the json body of the errors of the api.
+type apiError struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// writeError writes an error with the given status code as json.
+func writeError(w http.ResponseWriter, code int, format string, a ...interface{}) {
+	data, _ := json.Marshal(apiError{Status: code, Error: fmt.Sprintf(format, a...)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
 }
 
-func displayConfig(w http.ResponseWriter, conf *HTTPListenerConfig) {
-	data, err := json.Marshal(conf.TomlConf)
+// notFound writes a 404 for the paths the api does not serve.
+func notFound(w http.ResponseWriter, req *http.Request) {
+	writeError(w, http.StatusNotFound, "Path %s not found", req.URL.Path)
+}
+
+// writeJSON marshals v and writes it with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.Marshal(v)
 	if err != nil {
 		log.Errorf("Error while json marshal: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error while decoding struct to json")
+		writeError(w, http.StatusInternalServerError, "Error while encoding struct to json")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// displayLimits shows the write limits and usage of all the customers keyed by the customer name.
+func displayLimits(w http.ResponseWriter, conf *HTTPListenerConfig) {
+	limits := make(map[string]customerLimit)
+	for _, c := range conf.Customers.Keys() {
+		limits[c.Name] = newCustomerLimit(c)
+	}
+	writeJSON(w, http.StatusOK, limits)
+}
+
+// customerLimits shows (GET) or changes (PUT) the write limits of a single customer.
+// A PUT only changes the limits present in the json body.
+func customerLimits(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	name := strings.TrimPrefix(req.URL.Path, "/api/v1/limits/")
+	customer, ok := findCustomer(conf.Customers.Keys(), name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Customer %s not found", name)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, string(data))
+
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newCustomerLimit(customer))
+	case http.MethodPut:
+		l := customer.WriteLimiter.Limits()
+		if err := json.NewDecoder(req.Body).Decode(&l); err != nil {
+			writeError(w, http.StatusBadRequest, "Error while decoding json: %v", err)
+			return
+		}
+		if l.BatchRate < 0 || l.BatchBurst < 0 || l.ByteRate < 0 || l.ByteBurst < 0 || l.DailyBytes < 0 || l.DailyPoints < 0 {
+			writeError(w, http.StatusBadRequest, "Limits can not be negative")
+			return
+		}
+		customer.WriteLimiter.SetLimits(l)
+		log.Infof("Write limits of customer %s changed to %+v", name, l)
+		writeJSON(w, http.StatusOK, newCustomerLimit(customer))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+}
+
+// defaultTopMeasurements is the number of measurements shown by the usage api unless the top parameter is set.
+const defaultTopMeasurements = 10
+
+// usageReport is the json representation of the volumes written by a customer.
+type usageReport struct {
+	Totals          usage.Totals        `json:"totals"`
+	TopMeasurements []usage.Measurement `json:"top_measurements"`
+}
+
+func newUsageReport(c config.APIKeyConfig, top int) usageReport {
+	return usageReport{Totals: c.Usage.Totals(), TopMeasurements: c.Usage.Top(top)}
+}
+
+// topParam returns the value of the top parameter of the request.
+func topParam(req *http.Request) (int, error) {
+	t := req.URL.Query().Get("top")
+	if t == "" {
+		return defaultTopMeasurements, nil
+	}
+	n, err := strconv.Atoi(t)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("top must be a positive number")
+	}
+	return n, nil
+}
+
+// usageRecordsRequest tells whether a request to /api/v1/usage asks for the hourly usage records.
+func usageRecordsRequest(req *http.Request) bool {
+	q := req.URL.Query()
+	return q.Get("from") != "" || q.Get("to") != ""
+}
+
+// displayUsage shows the volumes written by all the customers keyed by the customer name. With from or to it
+// exports the hourly usage records like accounting.
+func displayUsage(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	if usageRecordsRequest(req) {
+		if conf.UsageStore == nil {
+			writeError(w, http.StatusNotFound, "Usage records are not enabled")
+			return
+		}
+		accounting(w, req, conf)
+		return
+	}
+	top, err := topParam(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	u := make(map[string]usageReport)
+	for _, c := range conf.Customers.Keys() {
+		u[c.Name] = newUsageReport(c, top)
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// customerUsage shows the volumes written by a single customer.
+func customerUsage(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	name := strings.TrimPrefix(req.URL.Path, "/api/v1/usage/")
+	customer, ok := findCustomer(conf.Customers.Keys(), name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Customer %s not found", name)
+		return
+	}
+	top, err := topParam(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newUsageReport(customer, top))
+}
+
+// parseTime parses a RFC3339 time or a date, def is returned if s is empty.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// accounting exports the hourly usage records of the hours in [from, to) as json or csv (format=csv).
+// The records of all the customers are exported unless customer is set.
+func accounting(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	q := req.URL.Query()
+	from, err := parseTime(q.Get("from"), time.Time{})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid from: %v", err)
+		return
+	}
+	to, err := parseTime(q.Get("to"), time.Now().Add(time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid to: %v", err)
+		return
+	}
+
+	records := conf.UsageStore.Records(from, to, q.Get("customer"))
+	switch q.Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+		w.WriteHeader(http.StatusOK)
+		if err := usage.WriteCSV(w, records); err != nil {
+			log.Errorf("Error writing usage records: %v", err)
+		}
+	case "", "json":
+		if records == nil {
+			records = []usage.Record{}
+		}
+		writeJSON(w, http.StatusOK, records)
+	default:
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+	}
+}
+
+// defaultMessagesLimit is the number of batches returned by a search unless the limit parameter is set.
+const defaultMessagesLimit = 100
+
+// messageTimeline shows the lifecycle events of a batch.
+func messageTimeline(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	id := strings.TrimPrefix(req.URL.Path, "/api/v1/messages/")
+	events := conf.Journal.Timeline(id)
+	if len(events) == 0 {
+		writeError(w, http.StatusNotFound, "Message %s not found", id)
+		return
+	}
+	writeJSON(w, http.StatusOK, lifecycle.Message{MessageID: id, Customer: events[0].Customer, Events: events})
+}
+
+// searchMessages shows the lifecycle events of the batches of a customer (all customers if not set)
+// received in [from, to), by default in the last hour.
+func searchMessages(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	q := req.URL.Query()
+	now := time.Now()
+	from, err := parseTime(q.Get("from"), now.Add(-time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid from: %v", err)
+		return
+	}
+	to, err := parseTime(q.Get("to"), now.Add(time.Minute))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid to: %v", err)
+		return
+	}
+	limit := defaultMessagesLimit
+	if l := q.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, conf.Journal.Search(q.Get("customer"), from, to, limit))
 }
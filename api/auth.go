@@ -0,0 +1,225 @@
+// Package api provides code to expose the running configs.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Roles of the api clients. Read-only clients can read the routes declared readable by them, operators
+// can read every route and change the state of the router.
+const (
+	RoleReadOnly = "read-only"
+	RoleOperator = "operator"
+)
+
+type authContext string
+
+const principalContextKey = authContext("principal")
+
+// Principal is an authenticated api client.
+type Principal struct {
+	Name string
+	Role string
+}
+
+// authFile is the toml file of the api clients.
+type authFile struct {
+	Tokens []struct {
+		Name  string
+		Token string
+		Role  string
+	}
+	Certificates []struct {
+		CommonName string `toml:"common_name"`
+		Role       string
+	}
+}
+
+// Auth authenticates the api clients with a bearer token or a verified client certificate and
+// authorizes them by role. A nil *Auth lets every request through.
+type Auth struct {
+	tokens map[string]Principal // by the sha256 of the token
+	certs  map[string]Principal // by the common name of the certificate
+}
+
+// LoadAuth reads the api clients from a toml file.
+func LoadAuth(path string) (*Auth, error) {
+	var f authFile
+	if _, err := toml.DecodeFile(path, &f); err != nil {
+		return nil, err
+	}
+	a := &Auth{tokens: make(map[string]Principal), certs: make(map[string]Principal)}
+	for _, t := range f.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("token of %s is empty", t.Name)
+		}
+		if err := checkRole(t.Role); err != nil {
+			return nil, err
+		}
+		a.tokens[tokenHash(t.Token)] = Principal{Name: t.Name, Role: t.Role}
+	}
+	for _, c := range f.Certificates {
+		if err := checkRole(c.Role); err != nil {
+			return nil, err
+		}
+		a.certs[c.CommonName] = Principal{Name: c.CommonName, Role: c.Role}
+	}
+	return a, nil
+}
+
+func checkRole(r string) error {
+	if r != RoleReadOnly && r != RoleOperator {
+		return fmt.Errorf("unknown role %q, must be %s or %s", r, RoleReadOnly, RoleOperator)
+	}
+	return nil
+}
+
+func tokenHash(t string) string {
+	h := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(h[:])
+}
+
+// authenticate returns the client of a request, from the bearer token or else from the client certificate.
+func (a *Auth) authenticate(req *http.Request) (Principal, bool) {
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		p, ok := a.tokens[tokenHash(strings.TrimSpace(strings.TrimPrefix(h, "Bearer ")))]
+		return p, ok
+	}
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		p, ok := a.certs[req.TLS.VerifiedChains[0][0].Subject.CommonName]
+		return p, ok
+	}
+	return Principal{}, false
+}
+
+// role returns the role needed to read a route. The requests but GET, HEAD and OPTIONS always need the
+// operator role.
+type role func(req *http.Request) string
+
+// readOnly routes show the state of the router.
+func readOnly(req *http.Request) string {
+	return RoleReadOnly
+}
+
+// operatorOnly routes show the raw data of the customers, the secrets of the config or the internals of
+// the router.
+func operatorOnly(req *http.Request) string {
+	return RoleOperator
+}
+
+// customerRole is the role of /api/v1/customers/: the tail streams the raw data of a customer.
+func customerRole(req *http.Request) string {
+	if strings.HasSuffix(path.Clean(req.URL.Path), "/tail") {
+		return RoleOperator
+	}
+	return RoleReadOnly
+}
+
+// usageRole is the role of /api/v1/usage: the hourly usage records have the emails of the customers.
+func usageRole(req *http.Request) string {
+	if usageRecordsRequest(req) {
+		return RoleOperator
+	}
+	return RoleReadOnly
+}
+
+// router adds the routes to a mux with the role needed to read them.
+type router struct {
+	mux   mux
+	roles map[string]role // by pattern
+}
+
+func newRouter(h mux) *router {
+	return &router{mux: h, roles: make(map[string]role)}
+}
+
+// handle adds a route readable with the given role.
+func (r *router) handle(pattern string, needs role, handler http.Handler) {
+	r.roles[pattern] = needs
+	r.mux.Handle(pattern, handler)
+}
+
+// requiredRole returns the role a request to a route needs. The routes without a role need the operator role.
+func requiredRole(needs role, req *http.Request) string {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return RoleOperator
+	}
+	if needs == nil {
+		return RoleOperator
+	}
+	return needs(req)
+}
+
+// allowed tells whether a role may make a request to a route.
+func allowed(r string, needs role, req *http.Request) bool {
+	return r == RoleOperator || r == requiredRole(needs, req)
+}
+
+// Handler authenticates every request and authorizes it with the role of the route of h it goes to, from
+// roles, before passing it to h.
+func (a *Auth) Handler(h *http.ServeMux, roles map[string]role) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, ok := a.authenticate(req)
+		if !ok {
+			log.Infof("[client %s] Unauthenticated api request %s %s", req.RemoteAddr, req.Method, req.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="influxdb-router"`)
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		_, pattern := h.Handler(req)
+		if !allowed(p.Role, roles[pattern], req) {
+			log.Infof("[client %s, principal %s] Forbidden api request %s %s", req.RemoteAddr, p.Name, req.Method, req.URL.Path)
+			writeError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalContextKey, p)))
+	})
+}
+
+// isOperator tells whether the client of a request has the operator role, always true if the api has no auth.
+func isOperator(req *http.Request) bool {
+	p, ok := req.Context().Value(principalContextKey).(Principal)
+	return !ok || p.Role == RoleOperator
+}
+
+// principalFromRequest returns the name of the client of a request, "anonymous" if the api has no auth.
+func principalFromRequest(req *http.Request) string {
+	if p, ok := req.Context().Value(principalContextKey).(Principal); ok {
+		return p.Name
+	}
+	return "anonymous"
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/usage"
+)
+
+const testAuthFile = `
+[[tokens]]
+  name = "grafana"
+  token = "read-token"
+  role = "read-only"
+
+[[tokens]]
+  name = "tooling"
+  token = "operator-token"
+  role = "operator"
+`
+
+func TestAuthHandler(t *testing.T) {
+	f, err := ioutil.TempFile("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testAuthFile)
+	f.Close()
+
+	a, err := LoadAuth(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.NewConfigs("../config/test_config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := config.NewAPIKeyMap(conf.Customers, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	roles := httpHandlers(mux, &HTTPListenerConfig{Customers: config.NewRegistry(conf, keys, "", false, ""), Auth: a, UsageStore: &usage.Store{}, Debug: true})
+	// A route added without a role is for the operators only.
+	var principal string
+	mux.Handle("/api/v1/undeclared", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { principal = principalFromRequest(req) }))
+	h := a.Handler(mux, roles)
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{"GET", "/api/v1/config", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/config", "wrong-token", http.StatusUnauthorized},
+		{"GET", "/api/v1/config", "read-token", http.StatusOK},
+		{"POST", "/api/v1/config", "read-token", http.StatusForbidden},
+		{"GET", "/api/v1/customers/servicex", "read-token", http.StatusOK},
+		{"GET", "/api/v1/customers/servicex/tail", "read-token", http.StatusForbidden},
+		{"GET", "/api/v1/accounting", "read-token", http.StatusForbidden},
+		{"GET", "/api/v1/usage", "read-token", http.StatusOK},
+		{"GET", "/api/v1/usage?from=2017-06-01&customer=servicex", "read-token", http.StatusForbidden},
+		{"GET", "/api/v1/usage?from=2017-06-01&customer=servicex", "operator-token", http.StatusOK},
+		{"GET", "/debug/pprof/heap", "read-token", http.StatusForbidden},
+		{"GET", "/debug/vars", "read-token", http.StatusForbidden},
+		{"GET", "/api/v1/debug/snapshot", "read-token", http.StatusForbidden},
+		{"GET", "/api/v1/undeclared", "read-token", http.StatusForbidden},
+		{"GET", "/api/v1/unknown", "read-token", http.StatusNotFound},
+		{"GET", "/api/v1/undeclared", "operator-token", http.StatusOK},
+		{"POST", "/api/v1/config", "operator-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.method, tt.path, tt.token)
+		if w.Code != tt.code {
+			t.Errorf("%s %s with %q Got: %d, Expected: %d", tt.method, tt.path, tt.token, w.Code, tt.code)
+		}
+	}
+	if principal != "tooling" {
+		t.Errorf("Got: %s, Expected: tooling", principal)
+	}
+
+	// The emails are for the operators only.
+	for _, p := range []string{"/api/v1/config", "/api/v1/customers", "/api/v1/customers/servicex"} {
+		if body := serve(h, "GET", p, "read-token").Body.String(); strings.Contains(body, "user1@email.com") || !strings.Contains(body, "servicex") {
+			t.Errorf("The email should be redacted for a read-only client. Got: %s", body)
+		}
+		if body := serve(h, "GET", p, "operator-token").Body.String(); !strings.Contains(body, "user1@email.com") {
+			t.Errorf("The email should be shown to an operator. Got: %s", body)
+		}
+	}
+}
+
+func serve(h http.Handler, method string, path string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoadAuthUnknownRole(t *testing.T) {
+	f, err := ioutil.TempFile("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[[tokens]]\n  name = \"x\"\n  token = \"t\"\n  role = \"admin\"\n")
+	f.Close()
+
+	if _, err := LoadAuth(f.Name()); err == nil {
+		t.Errorf("Expected an error for the unknown role")
+	}
+}
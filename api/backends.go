@@ -0,0 +1,62 @@
+// Package api provides code to expose the running configs.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+// backendStatus is the json representation of the state of a backend of a customer.
+type backendStatus struct {
+	Customer string `json:"customer"`
+	backends.BackendStatus
+}
+
+// customerBackends returns the state of the backends of a customer sorted by url.
+func customerBackends(c config.APIKeyConfig) []backendStatus {
+	statuses := make([]backendStatus, 0, len(c.Dests))
+	for _, d := range c.Dests {
+		statuses = append(statuses, backendStatus{Customer: c.Name, BackendStatus: d.Status()})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].URL < statuses[j].URL })
+	return statuses
+}
+
+// displayBackends shows the state of the backends of all the customers.
+func displayBackends(w http.ResponseWriter, conf *HTTPListenerConfig) {
+	statuses := []backendStatus{}
+	for _, c := range conf.Customers.Keys() {
+		statuses = append(statuses, customerBackends(c)...)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Customer != statuses[j].Customer {
+			return statuses[i].Customer < statuses[j].Customer
+		}
+		return statuses[i].URL < statuses[j].URL
+	})
+	writeJSON(w, http.StatusOK, statuses)
+}
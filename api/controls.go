@@ -0,0 +1,151 @@
+// Package api provides code to expose the running configs.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/writer"
+)
+
+// controlResult is the json representation of the outcome of an operational control.
+type controlResult struct {
+	Action   string `json:"action"`
+	Customer string `json:"customer,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Backends int    `json:"backends,omitempty"` // backends acted upon
+	Batches  int    `json:"batches,omitempty"`  // batches purged
+}
+
+// backendControl serves /api/v1/backends/<action>?url=<url>[&customer=<name>]. The action applies to the
+// backends with the url of all the customers, or of a single customer.
+//
+//	drain:  no more writes, the batches stay in the queues
+//	resume: writes again to a drained backend
+//	flush:  writes the batches of the retry queue right away, whatever the health of the backend
+func backendControl(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+		return
+	}
+	action := strings.TrimPrefix(req.URL.Path, "/api/v1/backends/")
+	url, customer := req.URL.Query().Get("url"), req.URL.Query().Get("customer")
+
+	var dests []*backends.BackendDest
+	for _, c := range conf.Customers.Keys() {
+		if customer != "" && c.Name != customer {
+			continue
+		}
+		for _, d := range c.Dests {
+			if d.URL == url {
+				dests = append(dests, d)
+			}
+		}
+	}
+	if len(dests) == 0 {
+		writeError(w, http.StatusNotFound, "Backend %s not found", url)
+		return
+	}
+
+	// All the backends are checked before any is changed.
+	switch action {
+	case "drain", "resume":
+	case "flush":
+		for _, d := range dests {
+			if d.Drained() {
+				writeError(w, http.StatusConflict, "Backend %s is drained", url)
+				return
+			}
+		}
+	default:
+		notFound(w, req)
+		return
+	}
+	for _, d := range dests {
+		switch action {
+		case "drain":
+			d.SetDrained(true)
+		case "resume":
+			d.SetDrained(false)
+		case "flush":
+			d.Flush()
+		}
+	}
+	auditAction(req, action+" backend", logrus.Fields{"url": url, "customer": customer})
+	writeJSON(w, http.StatusOK, controlResult{Action: action, Customer: customer, URL: url, Backends: len(dests)})
+}
+
+// customerControl acts on a customer.
+//
+//	pause:  the listener rejects the writes and queries of the customer with a 503
+//	resume: takes the customer out of pause
+//	purge:  drops the batches in the incoming queue, the one held back by the dispatcher and the ones in the
+//	        out going and retry queues of the customer
+func customerControl(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig, c config.APIKeyConfig, action string) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+		return
+	}
+	res := controlResult{Action: action, Customer: c.Name}
+	switch action {
+	case "pause":
+		c.Pause.Set(true)
+	case "resume":
+		c.Pause.Set(false)
+	case "purge":
+		for p := conf.Ingress.Pop(c.IncomingQueue); p != nil; p = conf.Ingress.Pop(c.IncomingQueue) {
+			writer.DropBatch(p, c.Name, "", "purged")
+			res.Batches++
+		}
+		// The dispatcher does not write a held batch taken here.
+		for _, p := range conf.Ingress.Held(c.IncomingQueue) {
+			writer.DropBatch(p, c.Name, "", "purged")
+			res.Batches++
+		}
+		for _, d := range c.Dests {
+			for _, p := range d.Purge() {
+				writer.DropBatch(p, c.Name, d.URL, "purged")
+				res.Batches++
+			}
+		}
+	default:
+		notFound(w, req)
+		return
+	}
+	auditAction(req, action+" customer", logrus.Fields{"customer": c.Name, "batches": res.Batches})
+	writeJSON(w, http.StatusOK, res)
+}
+
+// auditAction writes an action taken with the api to the audit log.
+func auditAction(req *http.Request, action string, fields logrus.Fields) {
+	fields["principal"] = principalFromRequest(req)
+	fields["remote_addr"] = req.RemoteAddr
+	fields["action"] = action
+	audit.WithFields(fields).Info("Api action")
+}
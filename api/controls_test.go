@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/writer"
+)
+
+func TestDrainSurvivesCustomerUpdate(t *testing.T) {
+	customers := config.NewRegistry(&config.Configs{}, config.APIKeyMap{}, "", false, "")
+	ingress := backends.NewIngress(100)
+	conf := &HTTPListenerConfig{Customers: customers, Ingress: ingress}
+	mux := http.NewServeMux()
+	httpHandlers(mux, conf)
+	do := func(method string, path string, body string) int {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	url := "http://127.0.0.1:1"
+	if code := do("POST", "/api/v1/customers", `{"name": "a", "api_key": "5ca1ab1e", "influx_db_name": "telegraf", "influx_hosts": ["`+url+`"]}`); code != http.StatusCreated {
+		t.Fatalf("Create Got: %d, Expected: %d", code, http.StatusCreated)
+	}
+	ready, stop, stopped := make(chan bool, 1), make(chan struct{}), make(chan struct{})
+	go func() {
+		writer.OutQueueWriter(customers, ingress, ready, stop)
+		close(stopped)
+	}()
+	<-ready
+	defer func() {
+		close(stop)
+		<-stopped
+		for _, c := range customers.Keys() {
+			for _, d := range c.Dests {
+				d.Stop()
+			}
+		}
+	}()
+
+	if code := do("POST", "/api/v1/backends/drain?url="+url, ""); code != http.StatusOK {
+		t.Fatalf("Drain Got: %d, Expected: %d", code, http.StatusOK)
+	}
+	if code := do("PUT", "/api/v1/customers/a", `{"influx_db_name": "telegraf2", "influx_hosts": ["`+url+`"]}`); code != http.StatusOK {
+		t.Fatalf("Update Got: %d, Expected: %d", code, http.StatusOK)
+	}
+
+	// The writer takes the backends of the updated customer over in the background.
+	d := customers.Keys()["5ca1ab1e"].Dests[config.BackendKey(url)]
+	deadline := time.Now().Add(2 * time.Second)
+	for !d.Drained() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !d.Drained() {
+		t.Errorf("Backend should stay drained after the customer is updated")
+	}
+}
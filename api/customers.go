@@ -0,0 +1,164 @@
+// Package api provides code to expose the running configs.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+// customerView returns the config of a customer with the secrets masked, and the email too unless the
+// client is an operator.
+func customerView(req *http.Request, conf *HTTPListenerConfig, name string) (config.CustomerView, bool) {
+	configs, keys := conf.Customers.Snapshot()
+	for _, v := range configs.View(keys) {
+		if v.Name == name {
+			if !isOperator(req) {
+				v.Email = config.Redact(v.Email)
+			}
+			return v, true
+		}
+	}
+	return config.CustomerView{}, false
+}
+
+// changeError writes the error of a change of the customers.
+func changeError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	switch err {
+	case config.ErrCustomerNotFound:
+		code = http.StatusNotFound
+	case config.ErrCustomerExists:
+		code = http.StatusConflict
+	}
+	writeError(w, code, "%v", err)
+}
+
+// decodeCustomer reads the config of a customer from the json body of a request. Unknown fields and
+// masked secrets are rejected.
+func decodeCustomer(w http.ResponseWriter, req *http.Request) (config.Config, bool) {
+	var in config.CustomerInput
+	d := json.NewDecoder(req.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid customer: %v", err)
+		return config.Config{}, false
+	}
+	c, err := in.Config()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid customer: %v", err)
+		return c, false
+	}
+	return c, true
+}
+
+// customers lists the customers on GET and creates a customer on POST.
+func customers(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	switch req.Method {
+	case http.MethodGet:
+		displayConfig(w, req, conf)
+	case http.MethodPost:
+		c, ok := decodeCustomer(w, req)
+		if !ok {
+			return
+		}
+		if err := conf.Customers.Create(c); err != nil {
+			changeError(w, err)
+			return
+		}
+		auditAction(req, "create customer", logrus.Fields{"customer": *c.Name})
+		v, _ := customerView(req, conf, *c.Name)
+		writeJSON(w, http.StatusCreated, v)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+}
+
+// customerResource serves /api/v1/customers/<name>, /api/v1/customers/<name>/backends, the tail
+// /api/v1/customers/<name>/tail and the controls
+// /api/v1/customers/<name>/<pause|resume|purge>.
+func customerResource(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/api/v1/customers/"), "/")
+	name := parts[0]
+	if len(parts) == 2 {
+		customer, ok := findCustomer(conf.Customers.Keys(), name)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Customer %s not found", name)
+			return
+		}
+		if parts[1] == "backends" {
+			writeJSON(w, http.StatusOK, customerBackends(customer))
+			return
+		}
+		if parts[1] == "tail" {
+			tailCustomer(w, req, conf, customer)
+			return
+		}
+		customerControl(w, req, conf, customer, parts[1])
+		return
+	}
+	if len(parts) != 1 {
+		notFound(w, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		v, ok := customerView(req, conf, name)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Customer %s not found", name)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	case http.MethodPut:
+		c, ok := decodeCustomer(w, req)
+		if !ok {
+			return
+		}
+		if c.Name == nil {
+			c.Name = &name
+		}
+		if err := conf.Customers.Update(name, c); err != nil {
+			changeError(w, err)
+			return
+		}
+		auditAction(req, "update customer", logrus.Fields{"customer": name})
+		v, _ := customerView(req, conf, *c.Name)
+		writeJSON(w, http.StatusOK, v)
+	case http.MethodDelete:
+		if err := conf.Customers.Delete(name); err != nil {
+			changeError(w, err)
+			return
+		}
+		auditAction(req, "delete customer", logrus.Fields{"customer": name})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+}
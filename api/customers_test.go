@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/samitpal/influxdb-router/config"
+)
+
+func TestUpdateCustomerSecrets(t *testing.T) {
+	customers := config.NewRegistry(&config.Configs{}, config.APIKeyMap{}, "", false, "")
+	conf := &HTTPListenerConfig{Customers: customers}
+	mux := http.NewServeMux()
+	httpHandlers(mux, conf)
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("POST", "/api/v1/customers", `{"name": "a", "api_key": "5ca1ab1e0ddba11", "influx_db_name": "telegraf", "influx_hosts": ["http://127.0.0.1:1"], "auth": {"username": "u", "password": "secret-password"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Create Got: %d %s, Expected: %d", w.Code, w.Body, http.StatusCreated)
+	}
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		// A view sent back as is has unknown fields.
+		{do("GET", "/api/v1/customers/a", "").Body.String(), http.StatusBadRequest},
+		{`{"influx_db_name": "telegraf", "influx_hosts": ["http://127.0.0.1:1"], "api_key": "***********ba11"}`, http.StatusBadRequest},
+		{`{"influx_db_name": "telegraf", "influx_hosts": ["http://127.0.0.1:1"], "auth": {"username": "u", "password": "***********word"}}`, http.StatusBadRequest},
+		{`{"influx_db_name": "telegraf", "influx_hosts": ["http://127.0.0.1:1"], "unknown": 1}`, http.StatusBadRequest},
+		{`{"influx_db_name": "telegraf2", "influx_hosts": ["http://127.0.0.1:1"]}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := do("PUT", "/api/v1/customers/a", tt.body); w.Code != tt.code {
+			t.Errorf("PUT %s Got: %d %s, Expected: %d", tt.body, w.Code, w.Body, tt.code)
+		}
+	}
+
+	c, _ := customers.Get("a")
+	if *c.APIKey != "5ca1ab1e0ddba11" || c.Auth.Password != "secret-password" || *c.InfluxDBName != "telegraf2" {
+		t.Errorf("The secrets should be kept. Got: %s, %s, %s", *c.APIKey, c.Auth.Password, *c.InfluxDBName)
+	}
+}
@@ -0,0 +1,237 @@
+// Package api provides code to expose the running configs.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+// debugHandlers adds the pprof, expvar and introspection routes. The command line is not served, it has the
+// password of the metrics database.
+func debugHandlers(r *router, conf *HTTPListenerConfig) {
+	r.handle("/debug/pprof/", operatorOnly, http.HandlerFunc(pprof.Index))
+	r.handle("/debug/pprof/cmdline", operatorOnly, http.HandlerFunc(notFound))
+	r.handle("/debug/pprof/profile", operatorOnly, http.HandlerFunc(pprof.Profile))
+	r.handle("/debug/pprof/symbol", operatorOnly, http.HandlerFunc(pprof.Symbol))
+	r.handle("/debug/pprof/trace", operatorOnly, http.HandlerFunc(pprof.Trace))
+
+	publishOnce.Do(func() {
+		expvar.Publish("influxdb_router", expvar.Func(func() interface{} { return newSnapshot(conf) }))
+	})
+	r.handle("/debug/vars", operatorOnly, http.HandlerFunc(debugVars))
+
+	r.handle("/api/v1/debug/goroutines", operatorOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, newGoroutineSummary(conf.Customers.Keys()))
+	}))
+	r.handle("/api/v1/debug/snapshot", operatorOnly, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, newSnapshot(conf))
+	}))
+}
+
+// publishOnce publishes the snapshot to expvar once, expvar panics if a name is published twice.
+var publishOnce sync.Once
+
+// debugVars serves the expvar variables like expvar.Handler, but without cmdline.
+func debugVars(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
+
+// functionCount is the number of goroutines started at a function.
+type functionCount struct {
+	Function   string `json:"function"`
+	Goroutines int    `json:"goroutines"`
+}
+
+// backendWrites is the number of writes in flight, each in its own goroutine, to a backend of a customer.
+type backendWrites struct {
+	Customer   string `json:"customer"`
+	URL        string `json:"url"`
+	Goroutines int    `json:"goroutines"`
+}
+
+// goroutineSummary is the json representation of the live goroutines.
+type goroutineSummary struct {
+	Total         int             `json:"total"`
+	ByFunction    []functionCount `json:"by_function"`
+	BackendWrites []backendWrites `json:"backend_writes"`
+}
+
+func newGoroutineSummary(ac config.APIKeyMap) goroutineSummary {
+	byFunction := goroutinesByFunction(allStacks())
+	s := goroutineSummary{ByFunction: []functionCount{}, BackendWrites: []backendWrites{}}
+	for f, n := range byFunction {
+		s.Total += n
+		s.ByFunction = append(s.ByFunction, functionCount{Function: f, Goroutines: n})
+	}
+	sort.Slice(s.ByFunction, func(i, j int) bool {
+		if s.ByFunction[i].Goroutines != s.ByFunction[j].Goroutines {
+			return s.ByFunction[i].Goroutines > s.ByFunction[j].Goroutines
+		}
+		return s.ByFunction[i].Function < s.ByFunction[j].Function
+	})
+	for _, c := range ac {
+		for _, d := range c.Dests {
+			s.BackendWrites = append(s.BackendWrites, backendWrites{Customer: c.Name, URL: d.URL, Goroutines: d.Status().InFlightWrites})
+		}
+	}
+	sort.Slice(s.BackendWrites, func(i, j int) bool {
+		if s.BackendWrites[i].Customer != s.BackendWrites[j].Customer {
+			return s.BackendWrites[i].Customer < s.BackendWrites[j].Customer
+		}
+		return s.BackendWrites[i].URL < s.BackendWrites[j].URL
+	})
+	return s
+}
+
+// allStacks returns the stack traces of all the goroutines.
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// goroutinesByFunction counts the goroutines of a stack dump by the function they were started at,
+// which is the last frame of their stack.
+func goroutinesByFunction(stacks []byte) map[string]int {
+	counts := make(map[string]int)
+	s := bufio.NewScanner(bytes.NewReader(stacks))
+	s.Buffer(make([]byte, 64*1024), len(stacks)+1)
+	function := ""
+	in := false
+	done := func() {
+		if in && function != "" {
+			counts[function]++
+		}
+		function, in = "", false
+	}
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "goroutine "):
+			done()
+			in = true
+		case line == "" || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "created by "):
+		case in:
+			// A frame is the function with its arguments, followed by its file and line.
+			if i := strings.LastIndex(line, "("); i > 0 {
+				function = line[:i]
+			}
+		}
+	}
+	done()
+	return counts
+}
+
+// memSnapshot is the json representation of the memory of the process.
+type memSnapshot struct {
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	StackInuse   uint64 `json:"stack_inuse_bytes"`
+	Sys          uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"gc_count"`
+	PauseTotalNs uint64 `json:"gc_pause_total_ns"`
+}
+
+// queueSnapshot is the json representation of the queues of a customer.
+type queueSnapshot struct {
+	Customer           string                   `json:"customer"`
+	IncomingQueueLen   int                      `json:"incoming_queue_length"`
+	IncomingQueueCap   int                      `json:"incoming_queue_cap"`
+	IncomingQueueBytes int64                    `json:"incoming_queue_bytes"`
+	Backends           []backends.BackendStatus `json:"backends"`
+}
+
+// snapshot is the json representation of the memory and the queues of the router.
+type snapshot struct {
+	Goroutines    int             `json:"goroutines"`
+	Memory        memSnapshot     `json:"memory"`
+	IngressLength int             `json:"ingress_length"`
+	IngressCap    int             `json:"ingress_cap"`
+	Customers     []queueSnapshot `json:"customers"`
+}
+
+func newSnapshot(conf *HTTPListenerConfig) snapshot {
+	ms := &runtime.MemStats{}
+	runtime.ReadMemStats(ms)
+	s := snapshot{
+		Goroutines: runtime.NumGoroutine(),
+		Memory: memSnapshot{
+			HeapAlloc:    ms.HeapAlloc,
+			HeapInuse:    ms.HeapInuse,
+			HeapObjects:  ms.HeapObjects,
+			StackInuse:   ms.StackInuse,
+			Sys:          ms.Sys,
+			NumGC:        ms.NumGC,
+			PauseTotalNs: ms.PauseTotalNs,
+		},
+		Customers: []queueSnapshot{},
+	}
+	if conf.Ingress != nil {
+		s.IngressLength, s.IngressCap = conf.Ingress.Len(), conf.Ingress.Cap
+	}
+	for _, c := range conf.Customers.Keys() {
+		q := queueSnapshot{Customer: c.Name, Backends: []backends.BackendStatus{}}
+		if c.IncomingQueue != nil {
+			q.IncomingQueueLen = len(c.IncomingQueue.Queue)
+			q.IncomingQueueCap = cap(c.IncomingQueue.Queue)
+			q.IncomingQueueBytes = c.IncomingQueue.Bytes.Used()
+		}
+		for _, b := range customerBackends(c) {
+			q.Backends = append(q.Backends, b.BackendStatus)
+		}
+		s.Customers = append(s.Customers, q)
+	}
+	sort.Slice(s.Customers, func(i, j int) bool { return s.Customers[i].Customer < s.Customers[j].Customer })
+	return s
+}
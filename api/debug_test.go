@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/samitpal/influxdb-router/config"
+)
+
+func TestDebugCmdline(t *testing.T) {
+	conf := &HTTPListenerConfig{Customers: config.NewRegistry(&config.Configs{}, config.APIKeyMap{}, "", false, "")}
+	mux := http.NewServeMux()
+	debugHandlers(newRouter(mux), conf)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/cmdline", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("The command line should not be served. Got: %d, Expected: %d", w.Code, http.StatusNotFound)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("Vars are not json: %v", err)
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Errorf("The command line should not be in the vars")
+	}
+	if _, ok := vars["memstats"]; !ok {
+		t.Errorf("The other vars should be kept. Got: %v", vars)
+	}
+}
+
+func TestGoroutinesByFunction(t *testing.T) {
+	stacks := []byte(`goroutine 1 [running]:
+main.main()
+	/src/main.go:10 +0x20
+
+goroutine 7 [select]:
+net/http.(*persistConn).roundTrip(0xc000, 0xc001)
+	/go/src/net/http/transport.go:2000 +0x30
+github.com/samitpal/influxdb-router/writer.writeInflux(0x1, 0x2, 0x3)
+	/src/writer/http_write.go:57 +0x40
+created by github.com/samitpal/influxdb-router/writer.InfluxWriter in goroutine 6
+	/src/writer/http_write.go:120 +0x50
+
+goroutine 8 [IO wait]:
+github.com/samitpal/influxdb-router/writer.writeInflux(0x4, 0x5, 0x6)
+	/src/writer/http_write.go:57 +0x40
+created by github.com/samitpal/influxdb-router/writer.InfluxWriter
+	/src/writer/http_write.go:120 +0x50
+`)
+	got := goroutinesByFunction(stacks)
+	expected := map[string]int{"main.main": 1, "github.com/samitpal/influxdb-router/writer.writeInflux": 2}
+	if len(got) != len(expected) {
+		t.Fatalf("Wrong functions. Got: %v, Expected: %v", got, expected)
+	}
+	for f, n := range expected {
+		if got[f] != n {
+			t.Errorf("Wrong number of goroutines of %s. Got: %d, Expected: %d", f, got[f], n)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/samitpal/influxdb-router/lifecycle"
+	"github.com/samitpal/influxdb-router/stats"
+	"github.com/samitpal/influxdb-router/usage"
+)
+
+func openAPIPaths(t *testing.T) map[string]map[string]interface{} {
+	var spec struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(openAPISpec), &spec); err != nil {
+		t.Fatalf("OpenAPI document is not valid json: %v", err)
+	}
+	return spec.Paths
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	for p := range openAPIPaths(t) {
+		if !strings.HasPrefix(p, "/api/v1/") {
+			t.Errorf("Path %s is not versioned", p)
+		}
+	}
+}
+
+// routes records the patterns added by httpHandlers.
+type routes []string
+
+func (r *routes) Handle(pattern string, handler http.Handler) {
+	*r = append(*r, pattern)
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	var r routes
+	httpHandlers(&r, &HTTPListenerConfig{
+		Auth:       &Auth{},
+		Prometheus: &stats.Prometheus{},
+		UsageStore: &usage.Store{},
+		Journal:    &lifecycle.Journal{},
+		Debug:      true,
+	})
+	paths := openAPIPaths(t)
+
+	// Every route of the versioned api is documented, /metrics and /debug are not part of it.
+	for _, pattern := range r {
+		if !strings.HasPrefix(pattern, "/api/v1/") {
+			continue
+		}
+		documented := paths[pattern] != nil
+		if strings.HasSuffix(pattern, "/") {
+			for p := range paths {
+				if strings.HasPrefix(p, pattern) && len(p) > len(pattern) {
+					documented = true
+				}
+			}
+		}
+		if !documented {
+			t.Errorf("Route %s is not documented", pattern)
+		}
+	}
+
+	// Every documented path is served.
+	for p := range paths {
+		served := false
+		for _, pattern := range r {
+			if pattern == p || (strings.HasSuffix(pattern, "/") && pattern != "/" && strings.HasPrefix(p, pattern)) {
+				served = true
+			}
+		}
+		if !served {
+			t.Errorf("Path %s is documented but not served", p)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package api provides code to expose the running configs.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/tail"
+)
+
+// Defaults of the tails.
+const (
+	defaultTailDuration = time.Minute
+	defaultTailRate     = 100 // lines per second
+)
+
+// floatParam parses a query parameter between 0 and max, def is returned if it is not set.
+func floatParam(req *http.Request, name string, def float64, max float64) (float64, error) {
+	s := req.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || v > max {
+		return 0, fmt.Errorf("%s must be a number greater than 0 and at most %v", name, max)
+	}
+	return v, nil
+}
+
+// tailCustomer streams the lines of a sample of the batches received from a customer, as server-sent events
+// if the client accepts them, else as plain text. The tail ends after duration seconds, the lines over rate
+// lines per second are dropped. Only operators may tail, the lines are raw customer data.
+func tailCustomer(w http.ResponseWriter, req *http.Request, conf *HTTPListenerConfig, c config.APIKeyConfig) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+	sample, err := floatParam(req, "sample", 1, 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	rate, err := floatParam(req, "rate", defaultTailRate, conf.TailMaxRate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	seconds, err := floatParam(req, "duration", defaultTailDuration.Seconds(), conf.TailMaxDuration.Seconds())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	streamTail(w, req, flusher, c.Name, tail.Filter{
+		Measurement: req.URL.Query().Get("measurement"),
+		Sample:      sample,
+		Rate:        rate,
+	}, time.Duration(seconds*float64(time.Second)))
+}
+
+func streamTail(w http.ResponseWriter, req *http.Request, flusher http.Flusher, customer string, f tail.Filter, d time.Duration) {
+	t, err := tail.Default.Subscribe(customer, f)
+	if err != nil {
+		writeError(w, http.StatusTooManyRequests, "%v", err)
+		return
+	}
+	defer tail.Default.Unsubscribe(t)
+	auditAction(req, "tail customer", logrus.Fields{"customer": customer, "measurement": f.Measurement, "sample": f.Sample})
+
+	sse := req.Header.Get("Accept") == "text/event-stream"
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	start := time.Now()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	lines := 0
+	for {
+		select {
+		case l := <-t.Lines:
+			if sse {
+				fmt.Fprintf(w, "data: %s\n\n", l)
+			} else {
+				fmt.Fprintln(w, l)
+			}
+			lines++
+		case <-ticker.C:
+			flusher.Flush()
+		case <-timer.C:
+			summary := fmt.Sprintf("tail ended after %v: %d lines, %d dropped", time.Since(start).Round(time.Second), lines, t.Dropped())
+			if sse {
+				fmt.Fprintf(w, "event: end\ndata: %s\n\n", summary)
+			} else {
+				fmt.Fprintf(w, "# %s\n", summary)
+			}
+			flusher.Flush()
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Package apiclient is a client of the admin api of the influxdb router
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package apiclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/lifecycle"
+	"github.com/samitpal/influxdb-router/ratelimit"
+	"github.com/samitpal/influxdb-router/usage"
+)
+
+// Error is an error returned by the api.
+type Error struct {
+	Status  int    `json:"status"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("influxdb-router api: %d %s", e.Status, e.Message)
+}
+
+// BackendStatus is the state of a backend of a customer.
+type BackendStatus struct {
+	Customer string `json:"customer"`
+	backends.BackendStatus
+}
+
+// ControlResult is the outcome of an operational control.
+type ControlResult struct {
+	Action   string `json:"action"`
+	Customer string `json:"customer,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Backends int    `json:"backends,omitempty"`
+	Batches  int    `json:"batches,omitempty"`
+}
+
+// CustomerLimit is the write limits and usage of a customer.
+type CustomerLimit struct {
+	Limits ratelimit.WriteLimits `json:"limits"`
+	Usage  ratelimit.WriteUsage  `json:"usage"`
+}
+
+// UsageReport is the volumes written by a customer.
+type UsageReport struct {
+	Totals          usage.Totals        `json:"totals"`
+	TopMeasurements []usage.Measurement `json:"top_measurements"`
+}
+
+// Client calls the admin api of a router.
+type Client struct {
+	URL        string // e.g. http://localhost:8080
+	Token      string // bearer token, not sent if empty
+	HTTPClient *http.Client
+}
+
+// New returns a *Client of the api at baseURL authenticating with token.
+func New(baseURL string, token string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends a request with in as the json body, if not nil, and decodes the json response into out, if not nil.
+// The error bodies of the api are returned as *Error.
+func (c *Client) do(method string, path string, query url.Values, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	u := c.URL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		e := &Error{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil || e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		e.Status = resp.StatusCode
+		return e
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func customerPath(name string, parts ...string) string {
+	return "/api/v1/customers/" + strings.Join(append([]string{url.PathEscape(name)}, parts...), "/")
+}
+
+// Customers returns the config of the customers with the secrets masked.
+func (c *Client) Customers() ([]config.CustomerView, error) {
+	var v struct {
+		Customers []config.CustomerView `json:"customers"`
+	}
+	err := c.do(http.MethodGet, "/api/v1/customers", nil, nil, &v)
+	return v.Customers, err
+}
+
+// Customer returns the config of a customer with the secrets masked.
+func (c *Client) Customer(name string) (config.CustomerView, error) {
+	var v config.CustomerView
+	err := c.do(http.MethodGet, customerPath(name), nil, nil, &v)
+	return v, err
+}
+
+// CreateCustomer creates a customer.
+func (c *Client) CreateCustomer(customer config.CustomerInput) (config.CustomerView, error) {
+	var v config.CustomerView
+	err := c.do(http.MethodPost, "/api/v1/customers", nil, customer, &v)
+	return v, err
+}
+
+// UpdateCustomer changes a customer. The api key and the influxdb credentials are kept if not set.
+func (c *Client) UpdateCustomer(name string, customer config.CustomerInput) (config.CustomerView, error) {
+	var v config.CustomerView
+	err := c.do(http.MethodPut, customerPath(name), nil, customer, &v)
+	return v, err
+}
+
+// DeleteCustomer deletes a customer.
+func (c *Client) DeleteCustomer(name string) error {
+	return c.do(http.MethodDelete, customerPath(name), nil, nil, nil)
+}
+
+// PauseCustomer rejects the writes and queries of a customer until it is resumed.
+func (c *Client) PauseCustomer(name string) (ControlResult, error) {
+	return c.customerControl(name, "pause")
+}
+
+// ResumeCustomer takes a customer out of pause.
+func (c *Client) ResumeCustomer(name string) (ControlResult, error) {
+	return c.customerControl(name, "resume")
+}
+
+// PurgeCustomer drops the queued batches of a customer.
+func (c *Client) PurgeCustomer(name string) (ControlResult, error) {
+	return c.customerControl(name, "purge")
+}
+
+func (c *Client) customerControl(name string, action string) (ControlResult, error) {
+	var r ControlResult
+	err := c.do(http.MethodPost, customerPath(name, action), nil, nil, &r)
+	return r, err
+}
+
+// Backends returns the state of the backends of all the customers, or of a single customer if not empty.
+func (c *Client) Backends(customer string) ([]BackendStatus, error) {
+	path := "/api/v1/backends"
+	if customer != "" {
+		path = customerPath(customer, "backends")
+	}
+	var s []BackendStatus
+	err := c.do(http.MethodGet, path, nil, nil, &s)
+	return s, err
+}
+
+// DrainBackend stops the writes to the backends with the url, of all the customers or of a single customer.
+func (c *Client) DrainBackend(backendURL string, customer string) (ControlResult, error) {
+	return c.backendControl("drain", backendURL, customer)
+}
+
+// ResumeBackend writes again to drained backends.
+func (c *Client) ResumeBackend(backendURL string, customer string) (ControlResult, error) {
+	return c.backendControl("resume", backendURL, customer)
+}
+
+// FlushBackend writes the retry queues of backends right away.
+func (c *Client) FlushBackend(backendURL string, customer string) (ControlResult, error) {
+	return c.backendControl("flush", backendURL, customer)
+}
+
+func (c *Client) backendControl(action string, backendURL string, customer string) (ControlResult, error) {
+	q := url.Values{"url": {backendURL}}
+	if customer != "" {
+		q.Set("customer", customer)
+	}
+	var r ControlResult
+	err := c.do(http.MethodPost, "/api/v1/backends/"+action, q, nil, &r)
+	return r, err
+}
+
+// Limits returns the write limits and usage of a customer.
+func (c *Client) Limits(name string) (CustomerLimit, error) {
+	var l CustomerLimit
+	err := c.do(http.MethodGet, "/api/v1/limits/"+url.PathEscape(name), nil, nil, &l)
+	return l, err
+}
+
+// SetLimits changes the write limits of a customer.
+func (c *Client) SetLimits(name string, limits ratelimit.WriteLimits) (CustomerLimit, error) {
+	var l CustomerLimit
+	err := c.do(http.MethodPut, "/api/v1/limits/"+url.PathEscape(name), nil, limits, &l)
+	return l, err
+}
+
+// Usage returns the volumes written by a customer with its top measurements.
+func (c *Client) Usage(name string, top int) (UsageReport, error) {
+	var u UsageReport
+	err := c.do(http.MethodGet, "/api/v1/usage/"+url.PathEscape(name), url.Values{"top": {strconv.Itoa(top)}}, nil, &u)
+	return u, err
+}
+
+// Message returns the lifecycle of a batch.
+func (c *Client) Message(id string) (lifecycle.Message, error) {
+	var m lifecycle.Message
+	err := c.do(http.MethodGet, "/api/v1/messages/"+url.PathEscape(id), nil, nil, &m)
+	return m, err
+}
+
+// RevealSecret returns a secret of a customer (config.SecretAPIKey or config.SecretAuthPassword) in clear text.
+// It requires the operator role, the reason is written to the audit log.
+func (c *Client) RevealSecret(customer string, secret string, reason string) (string, error) {
+	var v struct {
+		Value string `json:"value"`
+	}
+	q := url.Values{"customer": {customer}, "secret": {secret}, "reason": {reason}}
+	err := c.do(http.MethodPost, "/api/v1/config/reveal", q, nil, &v)
+	return v.Value, err
+}
@@ -0,0 +1,73 @@
+package apiclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/samitpal/influxdb-router/api"
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+// newTestAPI serves the real api handlers with an operator token "secret" and no customer.
+func newTestAPI(t *testing.T) *httptest.Server {
+	f, err := ioutil.TempFile("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[[tokens]]\n  name = \"tooling\"\n  token = \"secret\"\n  role = \"operator\"\n")
+	f.Close()
+	auth, err := api.LoadAuth(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := api.HTTPListener(&api.HTTPListenerConfig{
+		Customers: config.NewRegistry(&config.Configs{}, config.APIKeyMap{}, "", false, ""),
+		Ingress:   backends.NewIngress(10),
+		Auth:      auth,
+	})
+	return httptest.NewServer(srv.Handler)
+}
+
+func TestClient(t *testing.T) {
+	srv := newTestAPI(t)
+	defer srv.Close()
+	c := New(srv.URL+"/", "secret")
+
+	key, name, db, hosts := "5ca1ab1e", "servicez", "telegraf3", []string{"http://127.0.0.1:8086"}
+	v, err := c.CreateCustomer(config.CustomerInput{APIKey: &key, Name: &name, InfluxDBName: &db, InfluxHosts: &hosts})
+	if err != nil || v.Name != name || v.InfluxDBName != db {
+		t.Fatalf("Wrong created customer. Got: %+v %v", v, err)
+	}
+	if customers, err := c.Customers(); err != nil || len(customers) != 1 || customers[0].Name != name {
+		t.Errorf("Wrong customers. Got: %+v %v", customers, err)
+	}
+
+	r, err := c.DrainBackend(hosts[0], "")
+	if err != nil || r.Action != "drain" || r.URL != hosts[0] || r.Backends != 1 {
+		t.Errorf("Wrong control result. Got: %+v %v", r, err)
+	}
+	b, err := c.Backends(name)
+	if err != nil || len(b) != 1 || b[0].URL != hosts[0] || !b[0].Drained {
+		t.Errorf("Wrong backends. Got: %+v %v", b, err)
+	}
+	if r, err := c.PauseCustomer(name); err != nil || r.Action != "pause" || r.Customer != name {
+		t.Errorf("Wrong control result. Got: %+v %v", r, err)
+	}
+
+	_, err = c.Customer("a")
+	e, ok := err.(*Error)
+	if !ok || e.Status != http.StatusNotFound || e.Message != "Customer a not found" {
+		t.Errorf("Wrong error. Got: %v, Expected: 404 Customer a not found", err)
+	}
+
+	_, err = New(srv.URL, "wrong").Customers()
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusUnauthorized {
+		t.Errorf("Wrong error. Got: %v, Expected: 401", err)
+	}
+}
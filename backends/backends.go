@@ -29,6 +29,7 @@ import (
 	"time"
 
 	"github.com/samitpal/influxdb-router/logging"
+	"github.com/samitpal/influxdb-router/tracing"
 )
 
 var log = logging.For("backends")
@@ -38,6 +39,11 @@ type Payload struct {
 	MessageID string
 	Body      []byte
 	APIKey    string
+	Points    int // number of points in Body
+
+	Trace      tracing.SpanContext // span of the request the batch was received with
+	Received   time.Time           // when the batch was put in the incoming queue
+	Dispatched time.Time           // when the batch was copied to the out going queues
 }
 
 // BackendDest struct holds properties of an influxdb backend destination.
@@ -48,6 +54,15 @@ type BackendDest struct {
 	Registered time.Time
 	RetryQueue chan *Payload
 	Health     *health
+
+	QueueBytes      *ByteBudget // bytes held by Queue and by the writes of its batches in flight
+	RetryQueueBytes *ByteBudget // bytes held by RetryQueue and by the writes of its batches in flight
+
+	writes  writeStats
+	drained bool          // no writes while drained, the queues are kept
+	flush   chan struct{} // asks for the retry queue to be written right away
+	done    chan struct{} // closed when the backend is stopped
+	once    sync.Once
 }
 
 type health struct {
@@ -57,6 +72,10 @@ type health struct {
 	unhealthyThreshold int
 	healthyThreshold   int
 	healthStatus       bool
+
+	lastHealthy         time.Time // when the backend last became healthy
+	lastUnhealthy       time.Time // when the backend last became unhealthy
+	consecutiveFailures int       // failed health checks in a row
 }
 
 //HealthCheck function does the influxdb health checks.
@@ -66,15 +85,21 @@ func (b *BackendDest) HealthCheck() {
 		Timeout: (time.Duration(b.Health.timeout) * time.Second),
 	}
 	log.Infof("Starting health check for url %s", b.Health.url)
-	interval := time.Tick(time.Duration(b.Health.interval) * time.Second)
+	ticker := time.NewTicker(time.Duration(b.Health.interval) * time.Second)
+	defer ticker.Stop()
 	for {
-		<-interval
+		select {
+		case <-ticker.C:
+		case <-b.done:
+			return
+		}
 		resp, err := client.Head(b.Health.url)
 
 		if err == nil {
 			resp.Body.Close()
 			if resp.StatusCode == 204 {
 				unhealthyCount = 0
+				b.healthCheckDone(true)
 
 				if !b.GetHealth() {
 					if healthyCount >= b.Health.healthyThreshold {
@@ -85,6 +110,7 @@ func (b *BackendDest) HealthCheck() {
 				continue
 			} else {
 				healthyCount = 0
+				b.healthCheckDone(false)
 				if b.GetHealth() {
 					if unhealthyCount >= b.Health.unhealthyThreshold {
 						b.SetHealth(false)
@@ -95,6 +121,7 @@ func (b *BackendDest) HealthCheck() {
 			}
 		} else {
 			healthyCount = 0
+			b.healthCheckDone(false)
 			if b.GetHealth() {
 				if unhealthyCount >= b.Health.unhealthyThreshold {
 					b.SetHealth(false)
@@ -106,6 +133,26 @@ func (b *BackendDest) HealthCheck() {
 	}
 }
 
+// HealthSettings are the settings of the health check of a backend.
+type HealthSettings struct {
+	URL                string `json:"url"`
+	Timeout            int    `json:"timeout_seconds"`
+	Interval           int    `json:"interval_seconds"`
+	UnhealthyThreshold int    `json:"unhealthy_threshold"`
+	HealthyThreshold   int    `json:"healthy_threshold"`
+}
+
+// HealthSettings returns the settings of the health check of a backend.
+func (b *BackendDest) HealthSettings() HealthSettings {
+	return HealthSettings{
+		URL:                b.Health.url,
+		Timeout:            b.Health.timeout,
+		Interval:           b.Health.interval,
+		UnhealthyThreshold: b.Health.unhealthyThreshold,
+		HealthyThreshold:   b.Health.healthyThreshold,
+	}
+}
+
 // GetHealth returns the health of a backend
 func (b *BackendDest) GetHealth() bool {
 	b.RLock()
@@ -113,10 +160,28 @@ func (b *BackendDest) GetHealth() bool {
 	return b.Health.healthStatus
 }
 
+// healthCheckDone counts the failed health checks in a row.
+func (b *BackendDest) healthCheckDone(ok bool) {
+	b.Lock()
+	defer b.Unlock()
+	if ok {
+		b.Health.consecutiveFailures = 0
+	} else {
+		b.Health.consecutiveFailures++
+	}
+}
+
 // SetHealth sets the health of a backend
 func (b *BackendDest) SetHealth(s bool) {
 	b.Lock()
 	defer b.Unlock()
+	if b.Health.healthStatus != s {
+		if s {
+			b.Health.lastHealthy = time.Now()
+		} else {
+			b.Health.lastUnhealthy = time.Now()
+		}
+	}
 	b.Health.healthStatus = s
 	if s {
 		log.Infof("Backend: %s status is now healthy", b.URL)
@@ -125,14 +190,122 @@ func (b *BackendDest) SetHealth(s bool) {
 	}
 }
 
+// SetDrained puts the backend in drain mode, or takes it out of it. A drained backend is not written to,
+// the batches stay in its queues.
+func (b *BackendDest) SetDrained(d bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.drained = d
+}
+
+// Drained tells whether the backend is in drain mode.
+func (b *BackendDest) Drained() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.drained
+}
+
+// Flush asks for the batches of the retry queue to be written right away, whatever the health of the backend.
+func (b *BackendDest) Flush() {
+	select {
+	case b.flush <- struct{}{}:
+	default:
+	}
+}
+
+// Flushes returns a channel that receives a value when a flush of the retry queue is asked for.
+func (b *BackendDest) Flushes() <-chan struct{} {
+	return b.flush
+}
+
+// Stop stops the health check and the writers of the backend. The batches left in the queues stay there.
+func (b *BackendDest) Stop() {
+	b.once.Do(func() { close(b.done) })
+}
+
+// Done returns a channel that is closed when the backend is stopped.
+func (b *BackendDest) Done() <-chan struct{} {
+	return b.done
+}
+
+// TakeOver moves the batches queued to a stopped backend replaced by b and takes over its health and drain mode.
+// It returns the batches that did not fit in the queues of b.
+func (b *BackendDest) TakeOver(old *BackendDest) []*Payload {
+	b.Lock()
+	b.Health.healthStatus = old.GetHealth()
+	b.drained = old.Drained()
+	b.Unlock()
+
+	var dropped []*Payload
+	for _, p := range drain(old.Queue, old.QueueBytes) {
+		if !b.Enqueue(p) {
+			dropped = append(dropped, p)
+		}
+	}
+	for _, p := range drain(old.RetryQueue, old.RetryQueueBytes) {
+		if !b.EnqueueRetry(p) {
+			dropped = append(dropped, p)
+		}
+	}
+	return dropped
+}
+
+// Purge empties the out going and the retry queues and returns the batches they held.
+func (b *BackendDest) Purge() []*Payload {
+	return append(drain(b.Queue, b.QueueBytes), drain(b.RetryQueue, b.RetryQueueBytes)...)
+}
+
+// drain empties a queue without blocking.
+func drain(q chan *Payload, budget *ByteBudget) []*Payload {
+	var batches []*Payload
+	for {
+		select {
+		case p := <-q:
+			budget.Release(len(p.Body))
+			batches = append(batches, p)
+		default:
+			return batches
+		}
+	}
+}
+
+// Enqueue adds a batch to the out going queue without blocking. It returns false if the queue
+// is full or if the batch does not fit in the byte budget of the queue.
+func (b *BackendDest) Enqueue(p *Payload) bool {
+	return enqueue(b.Queue, b.QueueBytes, p)
+}
+
+// EnqueueRetry adds a batch to the retry queue without blocking. It returns false if the queue
+// is full or if the batch does not fit in the byte budget of the queue.
+func (b *BackendDest) EnqueueRetry(p *Payload) bool {
+	return enqueue(b.RetryQueue, b.RetryQueueBytes, p)
+}
+
+func enqueue(q chan *Payload, budget *ByteBudget, p *Payload) bool {
+	if !budget.Reserve(len(p.Body)) {
+		return false
+	}
+	select {
+	case q <- p:
+		return true
+	default:
+		budget.Release(len(p.Body))
+		return false
+	}
+}
+
 // NewBackendDest initializes a *BackendDest.
 func NewBackendDest(url string, outgoingQueueCap int, retryQueueCap int) *BackendDest {
 	// To-Do: Make the healthcheck url configurable.
 	healthCheckURL := url + "/ping"
 	backend := &BackendDest{
-		URL:        url,
-		Queue:      make(chan *Payload, outgoingQueueCap),
-		RetryQueue: make(chan *Payload, retryQueueCap),
+		URL:             url,
+		Queue:           make(chan *Payload, outgoingQueueCap),
+		RetryQueue:      make(chan *Payload, retryQueueCap),
+		QueueBytes:      NewByteBudget(0, GlobalBudget),
+		RetryQueueBytes: NewByteBudget(0, GlobalBudget),
+		flush:           make(chan struct{}, 1),
+		done:            make(chan struct{}),
 		Health: &health{
 			url:                healthCheckURL,
 			timeout:            3,
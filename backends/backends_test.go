@@ -1,8 +1,10 @@
 package backends
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var url = "http://localhost:8086"
@@ -50,3 +52,54 @@ func TestSetHealth(t *testing.T) {
 		t.Error("Health should be false")
 	}
 }
+
+func TestStatus(t *testing.T) {
+	b := NewBackendDest("http://127.0.0.1:8086", 10, 5)
+	b.Enqueue(&Payload{Body: []byte("cpu")})
+	b.SetHealth(true)
+	b.healthCheckDone(false)
+	b.healthCheckDone(false)
+
+	for i := 1; i <= 100; i++ {
+		b.WriteStarted()
+		var err error
+		if i == 100 {
+			err = errors.New("timeout")
+		}
+		b.WriteFinished(time.Duration(i)*time.Millisecond, err)
+	}
+	b.WriteStarted()
+
+	s := b.Status()
+	if !s.Healthy || s.LastHealthy == nil || s.LastUnhealthy != nil {
+		t.Errorf("Health does not match. Got: %v, %v, %v", s.Healthy, s.LastHealthy, s.LastUnhealthy)
+	}
+	if s.ConsecutiveFailures != 2 {
+		t.Errorf("ConsecutiveFailures Got: %d, Expected: 2", s.ConsecutiveFailures)
+	}
+	if s.QueueLength != 1 || s.QueueCap != 10 || s.RetryQueueCap != 5 {
+		t.Errorf("Queues do not match. Got: %d/%d, %d", s.QueueLength, s.QueueCap, s.RetryQueueCap)
+	}
+	if s.InFlightWrites != 1 {
+		t.Errorf("InFlightWrites Got: %d, Expected: 1", s.InFlightWrites)
+	}
+	if s.LastWriteError != "timeout" {
+		t.Errorf("LastWriteError Got: %s, Expected: timeout", s.LastWriteError)
+	}
+	if s.LatencyP50 != 0.05 || s.LatencyP99 != 0.099 {
+		t.Errorf("Latency Got: %v, %v, Expected: 0.05, 0.099", s.LatencyP50, s.LatencyP99)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	b := NewBackendDest("http://127.0.0.1:8086", 10, 10)
+	b.Enqueue(&Payload{MessageID: "a", Body: []byte("cpu")})
+	b.EnqueueRetry(&Payload{MessageID: "b", Body: []byte("mem")})
+
+	if purged := b.Purge(); len(purged) != 2 || purged[0].MessageID != "a" || b.QueueBytes.Used() != 0 || b.RetryQueueBytes.Used() != 0 {
+		t.Errorf("Got: %d purged batches, Expected: 2", len(purged))
+	}
+	if len(b.Queue) != 0 || len(b.RetryQueue) != 0 {
+		t.Errorf("Queues should be empty. Got: %d, %d", len(b.Queue), len(b.RetryQueue))
+	}
+}
@@ -0,0 +1,97 @@
+// Package backends provides code for influxdb backends.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package backends
+
+import (
+	"sync/atomic"
+)
+
+// GlobalBudget is the memory budget of the whole process. Every queue budget is charged to it as well.
+// Batches shared by the out going queues of several backends are counted once per queue, so the
+// accounting errs on the safe side.
+var GlobalBudget = NewByteBudget(0, nil)
+
+// ByteBudget keeps track of the bytes held by a queue against a limit. A limit of 0 means no limit.
+type ByteBudget struct {
+	used   int64 // first for 64-bit alignment of atomic operations
+	limit  int64
+	parent *ByteBudget
+}
+
+// NewByteBudget returns a budget of limit bytes which is also charged to parent, if not nil.
+func NewByteBudget(limit int64, parent *ByteBudget) *ByteBudget {
+	return &ByteBudget{limit: limit, parent: parent}
+}
+
+// Reserve charges n bytes to the budget and its parents. It returns false, charging nothing,
+// if that would exceed any of the limits.
+func (b *ByteBudget) Reserve(n int) bool {
+	if b == nil {
+		return true
+	}
+	l := atomic.LoadInt64(&b.limit)
+	if u := atomic.AddInt64(&b.used, int64(n)); l > 0 && u > l {
+		atomic.AddInt64(&b.used, -int64(n))
+		return false
+	}
+	if !b.parent.Reserve(n) {
+		atomic.AddInt64(&b.used, -int64(n))
+		return false
+	}
+	return true
+}
+
+// Fits tells whether n more bytes would fit in the budget and its parents right now.
+func (b *ByteBudget) Fits(n int) bool {
+	if b == nil {
+		return true
+	}
+	if l := atomic.LoadInt64(&b.limit); l > 0 && atomic.LoadInt64(&b.used)+int64(n) > l {
+		return false
+	}
+	return b.parent.Fits(n)
+}
+
+// Release gives back n bytes reserved earlier.
+func (b *ByteBudget) Release(n int) {
+	if b == nil {
+		return
+	}
+	atomic.AddInt64(&b.used, -int64(n))
+	b.parent.Release(n)
+}
+
+// Used returns the bytes currently charged to the budget.
+func (b *ByteBudget) Used() int64 {
+	return atomic.LoadInt64(&b.used)
+}
+
+// Limit returns the limit of the budget, 0 means no limit.
+func (b *ByteBudget) Limit() int64 {
+	return atomic.LoadInt64(&b.limit)
+}
+
+// SetLimit changes the limit of the budget. Bytes already charged are kept even if over the new limit.
+func (b *ByteBudget) SetLimit(l int64) {
+	atomic.StoreInt64(&b.limit, l)
+}
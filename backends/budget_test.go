@@ -0,0 +1,49 @@
+package backends
+
+import (
+	"testing"
+)
+
+func TestByteBudget(t *testing.T) {
+	global := NewByteBudget(150, nil)
+	a := NewByteBudget(100, global)
+	b := NewByteBudget(0, global)
+
+	if !a.Reserve(100) {
+		t.Fatal("Reserve should succeed within the limit")
+	}
+	if a.Reserve(1) {
+		t.Error("Reserve should fail over the queue limit")
+	}
+	if b.Reserve(51) {
+		t.Error("Reserve should fail over the global limit")
+	}
+	if b.Fits(51) || !b.Fits(50) || a.Fits(1) {
+		t.Error("Fits should check the queue and the global limits")
+	}
+	if b.Used() != 0 || global.Used() != 100 {
+		t.Errorf("Failed reserve should not charge anything. Got: %d/%d, Expected: 0/100", b.Used(), global.Used())
+	}
+	if !b.Reserve(50) {
+		t.Error("Reserve should succeed within the global limit")
+	}
+
+	a.Release(100)
+	if a.Used() != 0 || global.Used() != 50 {
+		t.Errorf("Release should give back the bytes to the parent. Got: %d/%d, Expected: 0/50", a.Used(), global.Used())
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	b := NewBackendDest("http://localhost:8086", 10, 10)
+	b.QueueBytes.SetLimit(10)
+	if !b.Enqueue(&Payload{Body: make([]byte, 6)}) {
+		t.Fatal("Enqueue should succeed within the byte budget")
+	}
+	if b.Enqueue(&Payload{Body: make([]byte, 6)}) {
+		t.Error("Enqueue should fail over the byte budget")
+	}
+	if len(b.Queue) != 1 || b.QueueBytes.Used() != 6 {
+		t.Errorf("Queue does not match. Got: %d batches/%d bytes, Expected: 1/6", len(b.Queue), b.QueueBytes.Used())
+	}
+}
@@ -0,0 +1,161 @@
+// Package backends provides code for influxdb backends.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package backends
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// IncomingQueue is the in-memory queue of the batches received from a customer.
+type IncomingQueue struct {
+	Queue    chan *Payload
+	Bytes    *ByteBudget // bytes held by the queue
+	Weight   int         // share of the dispatcher relative to the other customers of the same priority
+	Priority int         // customers with a higher priority are always dispatched first
+}
+
+// NewIncomingQueue initializes an *IncomingQueue.
+func NewIncomingQueue(queueCap int, weight int, priority int) *IncomingQueue {
+	return &IncomingQueue{
+		Queue:    make(chan *Payload, queueCap),
+		Bytes:    NewByteBudget(0, GlobalBudget),
+		Weight:   weight,
+		Priority: priority,
+	}
+}
+
+// Ingress keeps track of the batches held in all the incoming queues so that the
+// total stays within a global cap, and wakes up the dispatcher when batches arrive.
+// It also keeps the batches the dispatcher popped but did not dispatch yet, so that
+// they are not lost on shutdown.
+type Ingress struct {
+	count int64 // first for 64-bit alignment of atomic operations
+	Cap   int
+	ready chan struct{}
+
+	heldLock sync.Mutex
+	held     map[*Payload]*IncomingQueue
+}
+
+// NewIngress initializes an *Ingress holding at most queueCap batches across all the incoming queues.
+func NewIngress(queueCap int) *Ingress {
+	return &Ingress{
+		Cap:   queueCap,
+		ready: make(chan struct{}, 1),
+		held:  make(map[*Payload]*IncomingQueue),
+	}
+}
+
+// Push adds a batch to an incoming queue. It returns false if either the queue or the ingress is full,
+// or if the batch does not fit in the byte budget of the queue.
+func (i *Ingress) Push(q *IncomingQueue, p *Payload) bool {
+	if !q.Bytes.Reserve(len(p.Body)) {
+		return false
+	}
+	if atomic.AddInt64(&i.count, 1) > int64(i.Cap) {
+		atomic.AddInt64(&i.count, -1)
+		q.Bytes.Release(len(p.Body))
+		return false
+	}
+	select {
+	case q.Queue <- p:
+	default:
+		atomic.AddInt64(&i.count, -1)
+		q.Bytes.Release(len(p.Body))
+		return false
+	}
+	select {
+	case i.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Pop removes a batch from an incoming queue without blocking. It returns nil if the queue is empty.
+func (i *Ingress) Pop(q *IncomingQueue) *Payload {
+	select {
+	case p := <-q.Queue:
+		atomic.AddInt64(&i.count, -1)
+		q.Bytes.Release(len(p.Body))
+		return p
+	default:
+		return nil
+	}
+}
+
+// Ready returns a channel that receives a value after batches have been pushed.
+func (i *Ingress) Ready() <-chan struct{} {
+	return i.ready
+}
+
+// Len returns the number of batches in all the incoming queues.
+func (i *Ingress) Len() int {
+	return int(atomic.LoadInt64(&i.count))
+}
+
+// Hold records a batch popped from an incoming queue that is not dispatched yet.
+func (i *Ingress) Hold(q *IncomingQueue, p *Payload) {
+	i.heldLock.Lock()
+	defer i.heldLock.Unlock()
+	i.held[p] = q
+}
+
+// Unhold forgets a batch recorded by Hold, once it is dispatched or dropped. It returns false if the batch
+// was already taken by Held, e.g. by a purge, and must not be dispatched.
+func (i *Ingress) Unhold(p *Payload) bool {
+	i.heldLock.Lock()
+	defer i.heldLock.Unlock()
+	_, ok := i.held[p]
+	delete(i.held, p)
+	return ok
+}
+
+// Holds tells whether a batch recorded by Hold was not taken by Held yet.
+func (i *Ingress) Holds(p *Payload) bool {
+	i.heldLock.Lock()
+	defer i.heldLock.Unlock()
+	_, ok := i.held[p]
+	return ok
+}
+
+// Held forgets and returns the batches of an incoming queue recorded by Hold.
+func (i *Ingress) Held(q *IncomingQueue) []*Payload {
+	i.heldLock.Lock()
+	defer i.heldLock.Unlock()
+	var batches []*Payload
+	for p, hq := range i.held {
+		if hq == q {
+			batches = append(batches, p)
+			delete(i.held, p)
+		}
+	}
+	return batches
+}
+
+// HeldLen returns the number of batches recorded by Hold.
+func (i *Ingress) HeldLen() int {
+	i.heldLock.Lock()
+	defer i.heldLock.Unlock()
+	return len(i.held)
+}
@@ -0,0 +1,54 @@
+package backends
+
+import (
+	"testing"
+)
+
+func TestIngress(t *testing.T) {
+	ingress := NewIngress(3)
+	a := NewIncomingQueue(2, 1, 0)
+	b := NewIncomingQueue(2, 1, 0)
+
+	if !ingress.Push(a, &Payload{}) || !ingress.Push(a, &Payload{}) {
+		t.Fatal("Push should succeed within the queue cap")
+	}
+	if ingress.Push(a, &Payload{}) {
+		t.Error("Push should fail once the incoming queue is full")
+	}
+	if !ingress.Push(b, &Payload{}) {
+		t.Error("Push to another incoming queue should succeed")
+	}
+	if ingress.Push(b, &Payload{}) {
+		t.Error("Push should fail once the ingress is full")
+	}
+	if ingress.Len() != 3 {
+		t.Errorf("Ingress length does not match. Got: %d, Expected: 3", ingress.Len())
+	}
+	if ingress.Pop(a) == nil || ingress.Len() != 2 {
+		t.Error("Pop should return a batch and decrement the ingress length")
+	}
+	ingress.Pop(a)
+	if ingress.Pop(a) != nil {
+		t.Error("Pop of an empty queue should return nil")
+	}
+}
+
+func TestIngressHold(t *testing.T) {
+	ingress := NewIngress(3)
+	a := NewIncomingQueue(2, 1, 0)
+	b := NewIncomingQueue(2, 1, 0)
+	p1, p2, p3 := &Payload{MessageID: "1"}, &Payload{MessageID: "2"}, &Payload{MessageID: "3"}
+	ingress.Hold(a, p1)
+	ingress.Hold(a, p2)
+	ingress.Hold(b, p3)
+	ingress.Unhold(p2)
+	if ingress.HeldLen() != 2 {
+		t.Errorf("Held length does not match. Got: %d, Expected: 2", ingress.HeldLen())
+	}
+	if held := ingress.Held(a); len(held) != 1 || held[0] != p1 {
+		t.Errorf("Held batches of a do not match. Got: %v", held)
+	}
+	if ingress.HeldLen() != 1 || len(ingress.Held(a)) != 0 {
+		t.Errorf("Held should forget the batches it returns")
+	}
+}
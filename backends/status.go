@@ -0,0 +1,144 @@
+// Package backends provides code for influxdb backends.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package backends
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is the number of most recent writes the latency percentiles are computed over.
+const latencyWindow = 1024
+
+// writeStats are the writes in flight, the last write error and the latencies of the recent writes to a backend.
+type writeStats struct {
+	sync.Mutex
+	inFlight      int
+	lastError     string
+	lastErrorTime time.Time
+	latencies     []float64 // ring buffer of the latencies in seconds
+	next          int
+}
+
+// BackendStatus is the state of a backend.
+type BackendStatus struct {
+	URL                 string     `json:"url"`
+	Healthy             bool       `json:"healthy"`
+	Drained             bool       `json:"drained"`
+	LastHealthy         *time.Time `json:"last_healthy,omitempty"`   // when the backend last became healthy
+	LastUnhealthy       *time.Time `json:"last_unhealthy,omitempty"` // when the backend last became unhealthy
+	ConsecutiveFailures int        `json:"consecutive_failures"`     // failed health checks in a row
+	QueueLength         int        `json:"queue_length"`
+	QueueCap            int        `json:"queue_cap"`
+	QueueBytes          int64      `json:"queue_bytes"`
+	RetryQueueLength    int        `json:"retry_queue_length"`
+	RetryQueueCap       int        `json:"retry_queue_cap"`
+	RetryQueueBytes     int64      `json:"retry_queue_bytes"`
+	InFlightWrites      int        `json:"in_flight_writes"`
+	LastWriteError      string     `json:"last_write_error,omitempty"`
+	LastWriteErrorTime  *time.Time `json:"last_write_error_time,omitempty"`
+	LatencyP50          float64    `json:"latency_p50_seconds"`
+	LatencyP99          float64    `json:"latency_p99_seconds"`
+}
+
+// WriteStarted records a write to the backend in flight.
+func (b *BackendDest) WriteStarted() {
+	b.writes.Lock()
+	b.writes.inFlight++
+	b.writes.Unlock()
+}
+
+// WriteFinished records the end of a write to the backend, with its latency and its error if it failed.
+func (b *BackendDest) WriteFinished(latency time.Duration, err error) {
+	w := &b.writes
+	w.Lock()
+	defer w.Unlock()
+	w.inFlight--
+	if err != nil {
+		w.lastError = err.Error()
+		w.lastErrorTime = time.Now()
+	}
+	if len(w.latencies) < latencyWindow {
+		w.latencies = append(w.latencies, latency.Seconds())
+		return
+	}
+	w.latencies[w.next] = latency.Seconds()
+	w.next = (w.next + 1) % latencyWindow
+}
+
+// Status returns the state of the backend.
+func (b *BackendDest) Status() BackendStatus {
+	s := BackendStatus{
+		URL:              b.URL,
+		QueueLength:      len(b.Queue),
+		QueueCap:         cap(b.Queue),
+		QueueBytes:       b.QueueBytes.Used(),
+		RetryQueueLength: len(b.RetryQueue),
+		RetryQueueCap:    cap(b.RetryQueue),
+		RetryQueueBytes:  b.RetryQueueBytes.Used(),
+	}
+
+	b.RLock()
+	s.Healthy = b.Health.healthStatus
+	s.Drained = b.drained
+	s.LastHealthy = timePtr(b.Health.lastHealthy)
+	s.LastUnhealthy = timePtr(b.Health.lastUnhealthy)
+	s.ConsecutiveFailures = b.Health.consecutiveFailures
+	b.RUnlock()
+
+	w := &b.writes
+	w.Lock()
+	s.InFlightWrites = w.inFlight
+	s.LastWriteError = w.lastError
+	s.LastWriteErrorTime = timePtr(w.lastErrorTime)
+	latencies := append([]float64(nil), w.latencies...)
+	w.Unlock()
+
+	sort.Float64s(latencies)
+	s.LatencyP50 = percentile(latencies, 0.5)
+	s.LatencyP99 = percentile(latencies, 0.99)
+	return s
+}
+
+// percentile returns the nearest rank percentile of sorted values, 0 if there are none.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
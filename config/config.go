@@ -28,9 +28,12 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"sync/atomic"
 
 	"github.com/BurntSushi/toml"
 	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/ratelimit"
+	"github.com/samitpal/influxdb-router/usage"
 )
 
 type errMandatoryField struct {
@@ -49,17 +52,35 @@ func newErr(f string) *errMandatoryField {
 type Config struct {
 	APIKey           *string   `toml:"api_key"`
 	Name             *string   `toml:"name"`
+	Email            *string   `toml:"email"`
 	InfluxHosts      *[]string `toml:"influx_hosts"`
 	InfluxDBName     *string   `toml:"influx_db_name"`
 	OutgoingQueueCap *int      `toml:"outgoing_queue_cap"`
 	Auth             *Authentication
 	RetryQueueCap    *int `toml:"retry_queue_cap"`
+	IncomingQueueCap *int `toml:"incoming_queue_cap"`
+	Weight           *int `toml:"weight"`   // share of the dispatcher relative to customers of the same priority
+	Priority         *int `toml:"priority"` // customers with a higher priority are dispatched first
+
+	IncomingQueueBytes *int64 `toml:"incoming_queue_bytes"` // max bytes in the incoming queue, 0 means no limit
+	OutgoingQueueBytes *int64 `toml:"outgoing_queue_bytes"` // max bytes in each out going queue, 0 means no limit
+	RetryQueueBytes    *int64 `toml:"retry_queue_bytes"`    // max bytes in each retry queue, 0 means no limit
+	QueryRateLimit     *int   `toml:"query_rate_limit"`     // queries per second, 0 means no limit
+	QueryBurst         *int   `toml:"query_burst"`          // max burst of queries above the rate
+	QueryConcurrency   *int   `toml:"query_concurrency"`    // max queries in flight, 0 means no limit
+
+	WriteBatchRateLimit *int   `toml:"write_batch_rate_limit"` // batches per second, 0 means no limit
+	WriteBatchBurst     *int   `toml:"write_batch_burst"`      // max burst of batches above the rate
+	WriteBytesRateLimit *int   `toml:"write_bytes_rate_limit"` // bytes per second, 0 means no limit
+	WriteBytesBurst     *int   `toml:"write_bytes_burst"`      // max burst of bytes above the rate
+	DailyBytesQuota     *int64 `toml:"daily_bytes_quota"`      // bytes per UTC day, 0 means no quota
+	DailyPointsQuota    *int64 `toml:"daily_points_quota"`     // points per UTC day, 0 means no quota
 }
 
 // Authentication for influxdb.
 type Authentication struct {
-	UserName string
-	Password string
+	UserName string `json:"username"`
+	Password string `json:"password"`
 }
 
 //Configs is a slice of Config
@@ -92,18 +113,50 @@ func (c *Configs) LogConfig() string {
 		buff.WriteString(fmt.Sprintf(
 			`ApiKey = %s
 Name = %s
+Email = %s
 InfluxHosts = %s
 InfluxDB = %v
 OutgoingQueueCap = %v
 RetryQueueCap = %v
+IncomingQueueCap = %v
+Weight = %v
+Priority = %v
+IncomingQueueBytes = %v
+OutgoingQueueBytes = %v
+RetryQueueBytes = %v
+QueryRateLimit = %v
+QueryBurst = %v
+QueryConcurrency = %v
+WriteBatchRateLimit = %v
+WriteBatchBurst = %v
+WriteBytesRateLimit = %v
+WriteBytesBurst = %v
+DailyBytesQuota = %v
+DailyPointsQuota = %v
 Auth.UserName = %v
 Auth.Password = %v`,
 			Mask(*r.APIKey, 4),
 			*r.Name,
+			*r.Email,
 			*r.InfluxHosts,
 			*r.InfluxDBName,
 			*r.OutgoingQueueCap,
 			*r.RetryQueueCap,
+			*r.IncomingQueueCap,
+			*r.Weight,
+			*r.Priority,
+			*r.IncomingQueueBytes,
+			*r.OutgoingQueueBytes,
+			*r.RetryQueueBytes,
+			*r.QueryRateLimit,
+			*r.QueryBurst,
+			*r.QueryConcurrency,
+			*r.WriteBatchRateLimit,
+			*r.WriteBatchBurst,
+			*r.WriteBytesRateLimit,
+			*r.WriteBytesBurst,
+			*r.DailyBytesQuota,
+			*r.DailyPointsQuota,
 			r.Auth.UserName,
 			Mask(r.Auth.Password, 4)))
 		buff.WriteString("\n-----------------------\n")
@@ -117,80 +170,247 @@ func (c *Configs) checkConfig() (*Configs, error) {
 
 	mroutes := []Config{}
 	for _, v := range c.Customers {
-		if v.APIKey == nil {
-			return nil, newErr("ApiKey")
-		}
-		if v.InfluxHosts == nil {
-			return nil, newErr("InfluxHosts")
+		v, err := checkCustomer(v)
+		if err != nil {
+			return nil, err
 		}
+		mroutes = append(mroutes, v)
+	}
+	c.Customers = mroutes
+	return c, nil
+}
 
-		if v.InfluxDBName == nil {
-			return nil, newErr("InfluxDBName")
-		}
+// checkCustomer validates the config of a customer and sets the defaults.
+func checkCustomer(v Config) (Config, error) {
+	if v.APIKey == nil {
+		return v, newErr("ApiKey")
+	}
+	if v.InfluxHosts == nil {
+		return v, newErr("InfluxHosts")
+	}
 
-		if v.Name == nil {
-			return nil, newErr("Service Name")
-		}
+	if v.InfluxDBName == nil {
+		return v, newErr("InfluxDBName")
+	}
 
-		if v.OutgoingQueueCap == nil {
-			o := 4096
-			v.OutgoingQueueCap = &o
-		}
-		if v.RetryQueueCap == nil {
-			r := 4096
-			v.RetryQueueCap = &r
-		}
-		if v.Auth == nil {
-			a := Authentication{}
-			v.Auth = &a
+	if v.Name == nil {
+		return v, newErr("Service Name")
+	}
+	// The name is a path element of the spill directory of the customer.
+	if n := *v.Name; n == "" || n == "." || n == ".." {
+		return v, fmt.Errorf("%q is not a valid customer name", n)
+	}
+
+	if v.Email == nil {
+		e := ""
+		v.Email = &e
+	}
+
+	if v.OutgoingQueueCap == nil {
+		o := 4096
+		v.OutgoingQueueCap = &o
+	}
+	if v.RetryQueueCap == nil {
+		r := 4096
+		v.RetryQueueCap = &r
+	}
+	if v.IncomingQueueCap == nil {
+		i := 4096
+		v.IncomingQueueCap = &i
+	}
+	for f, c := range map[string]int{
+		"outgoing_queue_cap": *v.OutgoingQueueCap,
+		"retry_queue_cap":    *v.RetryQueueCap,
+		"incoming_queue_cap": *v.IncomingQueueCap,
+	} {
+		if c < 1 {
+			return v, fmt.Errorf("%s of %s must be at least 1", f, *v.Name)
 		}
-		mroutes = append(mroutes, v)
 	}
-	c.Customers = mroutes
-	return c, nil
+	if v.Weight == nil {
+		w := 1
+		v.Weight = &w
+	}
+	if *v.Weight < 1 {
+		return v, fmt.Errorf("weight of %s must be at least 1", *v.Name)
+	}
+	if v.Priority == nil {
+		p := 0
+		v.Priority = &p
+	}
+	if v.IncomingQueueBytes == nil {
+		var b int64
+		v.IncomingQueueBytes = &b
+	}
+	if v.OutgoingQueueBytes == nil {
+		var b int64
+		v.OutgoingQueueBytes = &b
+	}
+	if v.RetryQueueBytes == nil {
+		var b int64
+		v.RetryQueueBytes = &b
+	}
+	if v.QueryRateLimit == nil {
+		q := 0
+		v.QueryRateLimit = &q
+	}
+	if v.QueryBurst == nil {
+		b := *v.QueryRateLimit
+		v.QueryBurst = &b
+	}
+	if v.QueryConcurrency == nil {
+		q := 0
+		v.QueryConcurrency = &q
+	}
+	if v.WriteBatchRateLimit == nil {
+		w := 0
+		v.WriteBatchRateLimit = &w
+	}
+	if v.WriteBatchBurst == nil {
+		b := *v.WriteBatchRateLimit
+		v.WriteBatchBurst = &b
+	}
+	if v.WriteBytesRateLimit == nil {
+		w := 0
+		v.WriteBytesRateLimit = &w
+	}
+	if v.WriteBytesBurst == nil {
+		b := *v.WriteBytesRateLimit
+		v.WriteBytesBurst = &b
+	}
+	if v.DailyBytesQuota == nil {
+		var d int64
+		v.DailyBytesQuota = &d
+	}
+	if v.DailyPointsQuota == nil {
+		var d int64
+		v.DailyPointsQuota = &d
+	}
+	if v.Auth == nil {
+		a := Authentication{}
+		v.Auth = &a
+	}
+	return v, nil
 }
 
 // APIKeyConfig contains the backend pool.
 type APIKeyConfig struct {
 	Dests            map[string]*backends.BackendDest
 	Name             string // service name
+	Email            string // contact of the service
 	InfluxDBName     string // database name in the backends
 	InfluxDBUserName string // db user name
 	InfluxDBPassword string // db password
 	OutgoingQueueCap int    // Max in-memory outgoing queue size
 	RetryQueueCap    int    // Max in-memory retry queue size
+
+	IncomingQueue *backends.IncomingQueue // Batches received from the customer waiting to be dispatched
+	QueryLimiter  *ratelimit.TokenBucket  // Queries per second limit, nil if unlimited
+	QuerySlots    *ratelimit.Concurrency  // Concurrent queries limit, nil if unlimited
+	WriteLimiter  *ratelimit.WriteLimiter // Write rate limits, quotas and usage
+	Usage         *usage.Tracker          // Volumes written, per measurement too
+	Pause         *Pause                  // The listener rejects the requests of a paused customer
+}
+
+// Pause is the pause switch of a customer, shared by the copies of its config.
+type Pause struct {
+	paused int32
+}
+
+// Set pauses or resumes the customer.
+func (p *Pause) Set(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&p.paused, v)
+}
+
+// Paused tells whether the customer is paused. A nil *Pause is never paused.
+func (p *Pause) Paused() bool {
+	if p == nil {
+		return false
+	}
+	return atomic.LoadInt32(&p.paused) == 1
 }
 
 // APIKeyMap is a mapping of the customer api key to Apiconfig
 type APIKeyMap map[string]APIKeyConfig
 
+// Successor returns the config of a customer after a change: the customer with the same api key, so that a
+// renamed customer is found, else the one with the same name, so that a customer whose api key changed is found.
+func (m APIKeyMap) Successor(apiKey string, name string) (APIKeyConfig, bool) {
+	if c, ok := m[apiKey]; ok {
+		return c, true
+	}
+	for _, c := range m {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return APIKeyConfig{}, false
+}
+
 // NewAPIKeyMap returns APIKey map from the toml configs.
 func NewAPIKeyMap(r []Config, authEnabled bool, authMode string) (APIKeyMap, error) {
 	rp := make(APIKeyMap)
 	for _, v := range r {
-		s := APIKeyConfig{}
-		s.InfluxDBName = *v.InfluxDBName
-		s.Name = *v.Name
-		s.OutgoingQueueCap = *v.OutgoingQueueCap
-		s.RetryQueueCap = *v.RetryQueueCap
-
-		err := checkURLS(*v.InfluxHosts)
+		s, err := newAPIKeyConfig(v, authEnabled, authMode)
 		if err != nil {
 			return nil, err
 		}
+		rp[*v.APIKey] = s
+	}
+	return rp, nil
+}
+
+// newAPIKeyConfig builds the runtime config of a customer, with its queues, backends and limiters.
+func newAPIKeyConfig(v Config, authEnabled bool, authMode string) (APIKeyConfig, error) {
+	s := APIKeyConfig{}
+	s.InfluxDBName = *v.InfluxDBName
+	s.Name = *v.Name
+	s.Email = *v.Email
+	s.OutgoingQueueCap = *v.OutgoingQueueCap
+	s.RetryQueueCap = *v.RetryQueueCap
+	s.IncomingQueue = backends.NewIncomingQueue(*v.IncomingQueueCap, *v.Weight, *v.Priority)
+	s.IncomingQueue.Bytes.SetLimit(*v.IncomingQueueBytes)
+	s.QueryLimiter = ratelimit.NewTokenBucket(float64(*v.QueryRateLimit), float64(*v.QueryBurst))
+	s.QuerySlots = ratelimit.NewConcurrency(*v.QueryConcurrency)
+	s.WriteLimiter = ratelimit.NewWriteLimiter(writeLimits(v))
+	s.Usage = usage.NewTracker(usage.DefaultMaxMeasurements)
+	s.Pause = &Pause{}
+
+	err := checkURLS(*v.InfluxHosts)
+	if err != nil {
+		return s, err
+	}
 
-		if authEnabled {
-			authenticator, err := AuthMode(authMode, v)
-			if err != nil {
-				return nil, err
-			}
-			s.InfluxDBUserName, s.InfluxDBPassword = authenticator.Creds(*v.Name)
+	if authEnabled {
+		authenticator, err := AuthMode(authMode, v)
+		if err != nil {
+			return s, err
 		}
+		s.InfluxDBUserName, s.InfluxDBPassword = authenticator.Creds(*v.Name)
+	}
 
-		s.Dests = genBackends(*v.InfluxHosts, *v.OutgoingQueueCap, *v.RetryQueueCap)
-		rp[*v.APIKey] = s
+	s.Dests = genBackends(*v.InfluxHosts, *v.OutgoingQueueCap, *v.RetryQueueCap)
+	for _, d := range s.Dests {
+		d.QueueBytes.SetLimit(*v.OutgoingQueueBytes)
+		d.RetryQueueBytes.SetLimit(*v.RetryQueueBytes)
+	}
+	return s, nil
+}
+
+// writeLimits returns the write limits of a customer.
+func writeLimits(v Config) ratelimit.WriteLimits {
+	return ratelimit.WriteLimits{
+		BatchRate:   *v.WriteBatchRateLimit,
+		BatchBurst:  *v.WriteBatchBurst,
+		ByteRate:    *v.WriteBytesRateLimit,
+		ByteBurst:   *v.WriteBytesBurst,
+		DailyBytes:  *v.DailyBytesQuota,
+		DailyPoints: *v.DailyPointsQuota,
 	}
-	return rp, nil
 }
 
 func checkURLS(us []string) error {
@@ -206,15 +426,19 @@ func checkURLS(us []string) error {
 func genBackends(hosts []string, outgoingQueueCap int, retryQueueCap int) map[string]*backends.BackendDest {
 	bs := make(map[string]*backends.BackendDest)
 	for _, v := range hosts {
-		h := md5.New()
-		io.WriteString(h, v)
-		hs := fmt.Sprintf("%x", h.Sum(nil))
 		b := backends.NewBackendDest(v, outgoingQueueCap, retryQueueCap)
-		bs[hs] = b
+		bs[BackendKey(v)] = b
 	}
 	return bs
 }
 
+// BackendKey returns the key of the backend with the given url in APIKeyConfig.Dests.
+func BackendKey(url string) string {
+	h := md5.New()
+	io.WriteString(h, url)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 // Mask masks the first len(s)-n characters.
 func Mask(s string, n int) string {
 	b := []byte(s)
@@ -3,15 +3,20 @@ package config
 import (
 	"os"
 	"testing"
+
+	"github.com/samitpal/influxdb-router/ratelimit"
 )
 
 //"reflect"
 
 var customerAPIKey = []string{"7ba4e75a", "97dafb09"}
 var customerName = []string{"servicex", "servicey"}
+var customerEmail = []string{"user1@email.com", "user2@email.com"}
 var customerInfluxDBName = []string{"telegraf1", "telegraf2"}
 var customerOutgoingQueueCap = []int{4096, 5000}
 var customerRetryQueueCap = []int{10, 4096}
+var customerQueryRateLimit = []int{0, 20}
+var customerQueryConcurrency = []int{0, 4}
 
 var gotConf, _ = NewConfigs("./test_config.toml")
 
@@ -26,6 +31,11 @@ func TestNewConfigs(t *testing.T) {
 			t.Errorf("Service Name does not match for customer%d. Got: %s, Expected: %s", i, *gotConf.Customers[i].Name, c)
 		}
 	}
+	for i, c := range customerEmail {
+		if c != *gotConf.Customers[i].Email {
+			t.Errorf("Email does not match for customer%d. Got: %s, Expected: %s", i, *gotConf.Customers[i].Email, c)
+		}
+	}
 	for i, c := range customerInfluxDBName {
 		if c != *gotConf.Customers[i].InfluxDBName {
 			t.Errorf("InfluxDBName does not match for customer%d. Got: %s, Expected: %s", i, *gotConf.Customers[i].InfluxDBName, c)
@@ -41,6 +51,16 @@ func TestNewConfigs(t *testing.T) {
 			t.Errorf("RetryQueueCap does not match for customer%d. Got: %d, Expected: %d", i, *gotConf.Customers[i].RetryQueueCap, c)
 		}
 	}
+	for i, c := range customerQueryRateLimit {
+		if c != *gotConf.Customers[i].QueryRateLimit {
+			t.Errorf("QueryRateLimit does not match for customer%d. Got: %d, Expected: %d", i, *gotConf.Customers[i].QueryRateLimit, c)
+		}
+	}
+	for i, c := range customerQueryConcurrency {
+		if c != *gotConf.Customers[i].QueryConcurrency {
+			t.Errorf("QueryConcurrency does not match for customer%d. Got: %d, Expected: %d", i, *gotConf.Customers[i].QueryConcurrency, c)
+		}
+	}
 }
 
 var gotBackEndDests = genBackends([]string{"http://127.0.0.1:8086", "http://1.2.3.4:8086"}, 400, 10)
@@ -156,6 +176,30 @@ func TestNewAPIKeyMap(t *testing.T) {
 	}
 }
 
+func TestQueryLimits(t *testing.T) {
+	gotAPIKeyMap, _ := NewAPIKeyMap(gotConf.Customers, false, "")
+	if gotAPIKeyMap["7ba4e75a"].QueryLimiter != nil || gotAPIKeyMap["7ba4e75a"].QuerySlots != nil {
+		t.Error("Query limits should not be set for servicex")
+	}
+	if r, b := gotAPIKeyMap["97dafb09"].QueryLimiter.Rate(); r != 20 || b != 20 {
+		t.Errorf("Query rate limit does not match for servicey. Got: %v/%v, Expected: 20/20", r, b)
+	}
+	if gotAPIKeyMap["97dafb09"].QuerySlots == nil {
+		t.Error("Query concurrency limit should be set for servicey")
+	}
+}
+
+func TestWriteLimits(t *testing.T) {
+	gotAPIKeyMap, _ := NewAPIKeyMap(gotConf.Customers, false, "")
+	if l := gotAPIKeyMap["7ba4e75a"].WriteLimiter.Limits(); l != (ratelimit.WriteLimits{}) {
+		t.Errorf("Write limits should not be set for servicex. Got: %+v", l)
+	}
+	exp := ratelimit.WriteLimits{BatchRate: 100, BatchBurst: 100, DailyBytes: 1073741824}
+	if l := gotAPIKeyMap["97dafb09"].WriteLimiter.Limits(); l != exp {
+		t.Errorf("Write limits do not match for servicey. Got: %+v, Expected: %+v", l, exp)
+	}
+}
+
 func TestMask(t *testing.T) {
 	s := "Hello World"
 	mString := "*******orld"
@@ -165,3 +209,42 @@ func TestMask(t *testing.T) {
 		t.Errorf("Returned masked String does not match. Got: %s, Expected: %s", m, mString)
 	}
 }
+
+func TestView(t *testing.T) {
+	ac, err := NewAPIKeyMap(gotConf.Customers, true, "from-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	views := gotConf.View(ac)
+	if len(views) != 2 {
+		t.Fatalf("Got: %d customers, Expected: 2", len(views))
+	}
+	v := views[0]
+	if v.APIKey != "********" {
+		t.Errorf("APIKey is not masked. Got: %s", v.APIKey)
+	}
+	if v.Auth.UserName != "user1" || v.Auth.Password != "*****ord1" {
+		t.Errorf("Auth is not masked. Got: %+v", v.Auth)
+	}
+	if v.IncomingQueueCap != 4096 || v.Weight != 1 {
+		t.Errorf("Defaults are not set. Got: %d, %d, Expected: 4096, 1", v.IncomingQueueCap, v.Weight)
+	}
+	if len(v.Backends) != 2 || v.Backends[0].URL != "http://127.0.0.1:8086" || v.Backends[0].HealthCheck.URL != "http://127.0.0.1:8086/ping" {
+		t.Errorf("Backends do not match. Got: %+v", v.Backends)
+	}
+
+	if s, ok := gotConf.Secret(ac, "servicey", SecretAuthPassword); !ok || s != "password2" {
+		t.Errorf("Secret does not match. Got: %s, Expected: password2", s)
+	}
+	if _, ok := gotConf.Secret(ac, "servicey", "email"); ok {
+		t.Errorf("Expected no secret email")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for s, expected := range map[string]string{"": "", "secret": "******", "Hello World": "*******orld"} {
+		if r := Redact(s); r != expected {
+			t.Errorf("Got: %s, Expected: %s", r, expected)
+		}
+	}
+}
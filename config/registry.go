@@ -0,0 +1,378 @@
+// Package config handles the configurations etc.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/BurntSushi/toml"
+	"github.com/samitpal/influxdb-router/ratelimit"
+)
+
+// Errors of the changes of the customers.
+var (
+	ErrCustomerNotFound = errors.New("customer not found")
+	ErrCustomerExists   = errors.New("customer already exists")
+)
+
+// Registry holds the customers and lets them be created, updated and deleted at runtime. Readers get
+// snapshots that are never modified, every change replaces them. The changes are saved to a state file,
+// the toml config file is left as the operators wrote it.
+type Registry struct {
+	sync.Mutex
+	path        string  // state file, empty if the changes are not saved
+	base        Configs // customers of the toml config file
+	authEnabled bool
+	authMode    string
+
+	state   atomic.Value // *registryState
+	changed chan struct{}
+}
+
+// State is what the state file holds: the customers created or changed with the api, which replace the
+// customers of the toml config file with the same name, and the customers of the toml config file that
+// were deleted or renamed.
+type State struct {
+	Customers []Config
+	Deleted   []string
+}
+
+type registryState struct {
+	configs Configs
+	keys    APIKeyMap
+	version uint64
+}
+
+// NewRegistry returns a *Registry of the customers of the toml config and their api key map. The changes are
+// saved to the state file at path, unless it is empty.
+func NewRegistry(conf *Configs, keys APIKeyMap, path string, authEnabled bool, authMode string) *Registry {
+	r := &Registry{path: path, base: *conf, authEnabled: authEnabled, authMode: authMode, changed: make(chan struct{})}
+	r.state.Store(&registryState{configs: *conf, keys: keys})
+	return r
+}
+
+// LoadRegistry returns a *Registry of the customers of the toml config with the changes saved in the state
+// file at path applied. A missing state file changes nothing.
+func LoadRegistry(conf *Configs, path string, authEnabled bool, authMode string) (*Registry, error) {
+	current, err := applyState(conf, path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := NewAPIKeyMap(current.Customers, authEnabled, authMode)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRegistry(conf, keys, path, authEnabled, authMode)
+	r.state.Store(&registryState{configs: *current, keys: keys})
+	return r, nil
+}
+
+// applyState returns the customers of the toml config with the changes of the state file applied.
+func applyState(conf *Configs, path string) (*Configs, error) {
+	if path == "" {
+		return conf, nil
+	}
+	var st State
+	if _, err := toml.DecodeFile(path, &st); err != nil {
+		if os.IsNotExist(err) {
+			return conf, nil
+		}
+		return nil, err
+	}
+	deleted := make(map[string]bool)
+	for _, n := range st.Deleted {
+		deleted[n] = true
+	}
+	changed := make(map[string]Config)
+	for _, c := range st.Customers {
+		c, err := checkCustomer(c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		changed[*c.Name] = c
+	}
+
+	current := &Configs{}
+	for _, c := range conf.Customers {
+		if deleted[*c.Name] {
+			continue
+		}
+		if n, ok := changed[*c.Name]; ok {
+			c = n
+			delete(changed, *c.Name)
+		}
+		current.Customers = append(current.Customers, c)
+	}
+	for _, c := range st.Customers {
+		if n, ok := changed[*c.Name]; ok {
+			current.Customers = append(current.Customers, n)
+		}
+	}
+	inUse := make(map[string]bool)
+	for _, c := range current.Customers {
+		if inUse[*c.APIKey] {
+			return nil, fmt.Errorf("%s: api key of %s is already in use", path, *c.Name)
+		}
+		inUse[*c.APIKey] = true
+	}
+	return current, nil
+}
+
+func (r *Registry) load() *registryState {
+	return r.state.Load().(*registryState)
+}
+
+// Keys returns the current api key map. It must not be modified.
+func (r *Registry) Keys() APIKeyMap {
+	return r.load().keys
+}
+
+// Snapshot returns the current toml config and api key map of the customers. They must not be modified.
+func (r *Registry) Snapshot() (Configs, APIKeyMap) {
+	s := r.load()
+	return s.configs, s.keys
+}
+
+// Version is incremented by every change.
+func (r *Registry) Version() uint64 {
+	return r.load().version
+}
+
+// Changed returns a channel that is closed at the next change.
+func (r *Registry) Changed() <-chan struct{} {
+	r.Lock()
+	defer r.Unlock()
+	return r.changed
+}
+
+// Get returns the toml config of a customer.
+func (r *Registry) Get(name string) (Config, bool) {
+	for _, c := range r.load().configs.Customers {
+		if *c.Name == name {
+			return c, true
+		}
+	}
+	return Config{}, false
+}
+
+// Create adds a customer.
+func (r *Registry) Create(c Config) error {
+	r.Lock()
+	defer r.Unlock()
+	s := r.load()
+	if _, ok := r.Get(stringValue(c.Name)); ok {
+		return ErrCustomerExists
+	}
+	return r.apply(s, -1, c)
+}
+
+// Update replaces the config of a customer. The api key and the auth are kept if they are not set.
+// The usage and the write limiter of the customer are carried over, with the limits changed at runtime
+// unless the new config changes them.
+func (r *Registry) Update(name string, c Config) error {
+	r.Lock()
+	defer r.Unlock()
+	s := r.load()
+	i := index(s.configs, name)
+	if i < 0 {
+		return ErrCustomerNotFound
+	}
+	old := s.configs.Customers[i]
+	if c.APIKey == nil {
+		c.APIKey = old.APIKey
+	}
+	if c.Auth == nil {
+		c.Auth = old.Auth
+	}
+	if stringValue(c.Name) != name {
+		if _, ok := r.Get(stringValue(c.Name)); ok {
+			return ErrCustomerExists
+		}
+	}
+	return r.apply(s, i, c)
+}
+
+// Delete removes a customer.
+func (r *Registry) Delete(name string) error {
+	r.Lock()
+	defer r.Unlock()
+	s := r.load()
+	i := index(s.configs, name)
+	if i < 0 {
+		return ErrCustomerNotFound
+	}
+	next := &registryState{keys: make(APIKeyMap, len(s.keys)), version: s.version + 1}
+	next.configs.Customers = append(append([]Config{}, s.configs.Customers[:i]...), s.configs.Customers[i+1:]...)
+	for k, v := range s.keys {
+		if k != *s.configs.Customers[i].APIKey {
+			next.keys[k] = v
+		}
+	}
+	return r.commit(next)
+}
+
+// apply validates the config of a customer and replaces the customer at index i with it, or adds it if i is -1.
+func (r *Registry) apply(s *registryState, i int, c Config) error {
+	c, err := checkCustomer(c)
+	if err != nil {
+		return err
+	}
+	for j, o := range s.configs.Customers {
+		if j != i && *o.APIKey == *c.APIKey {
+			return fmt.Errorf("api key of %s is already in use", *c.Name)
+		}
+	}
+	ac, err := newAPIKeyConfig(c, r.authEnabled, r.authMode)
+	if err != nil {
+		return err
+	}
+
+	next := &registryState{keys: make(APIKeyMap, len(s.keys)+1), version: s.version + 1}
+	next.configs.Customers = append([]Config{}, s.configs.Customers...)
+	for k, v := range s.keys {
+		next.keys[k] = v
+	}
+	if i < 0 {
+		next.configs.Customers = append(next.configs.Customers, c)
+	} else {
+		oldKey := *s.configs.Customers[i].APIKey
+		old := s.keys[oldKey]
+		ac.Usage = old.Usage
+		ac.WriteLimiter = old.WriteLimiter
+		// The limits changed at runtime with the api are kept unless the update changes them.
+		l := old.WriteLimiter.Limits()
+		if n := changedLimits(l, writeLimits(s.configs.Customers[i]), writeLimits(c)); n != l {
+			ac.WriteLimiter.SetLimits(n)
+		}
+		ac.Pause = old.Pause
+		delete(next.keys, oldKey)
+		next.configs.Customers[i] = c
+	}
+	next.keys[*c.APIKey] = ac
+	return r.commit(next)
+}
+
+// changedLimits returns the limits l with the limits that changed from old to next set to their next value.
+func changedLimits(l ratelimit.WriteLimits, old ratelimit.WriteLimits, next ratelimit.WriteLimits) ratelimit.WriteLimits {
+	if next.BatchRate != old.BatchRate {
+		l.BatchRate = next.BatchRate
+	}
+	if next.BatchBurst != old.BatchBurst {
+		l.BatchBurst = next.BatchBurst
+	}
+	if next.ByteRate != old.ByteRate {
+		l.ByteRate = next.ByteRate
+	}
+	if next.ByteBurst != old.ByteBurst {
+		l.ByteBurst = next.ByteBurst
+	}
+	if next.DailyBytes != old.DailyBytes {
+		l.DailyBytes = next.DailyBytes
+	}
+	if next.DailyPoints != old.DailyPoints {
+		l.DailyPoints = next.DailyPoints
+	}
+	return l
+}
+
+// commit saves the changes to the state file and makes the new state current. It must be called with the lock held.
+func (r *Registry) commit(next *registryState) error {
+	if r.path != "" {
+		if err := writeState(r.path, r.diff(next.configs)); err != nil {
+			return err
+		}
+	}
+	r.state.Store(next)
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return nil
+}
+
+// diff returns the changes of the customers from the ones of the toml config file.
+func (r *Registry) diff(c Configs) State {
+	var st State
+	base := make(map[string]Config)
+	for _, b := range r.base.Customers {
+		base[*b.Name] = b
+	}
+	current := make(map[string]bool)
+	for _, v := range c.Customers {
+		current[*v.Name] = true
+		if b, ok := base[*v.Name]; !ok || !reflect.DeepEqual(b, v) {
+			st.Customers = append(st.Customers, v)
+		}
+	}
+	for _, b := range r.base.Customers {
+		if !current[*b.Name] {
+			st.Deleted = append(st.Deleted, *b.Name)
+		}
+	}
+	return st
+}
+
+// writeState replaces the state file atomically.
+func writeState(path string, st State) error {
+	// The file has the api keys and the passwords of the customers, it is only readable by the owner.
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := toml.NewEncoder(f).Encode(st); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func index(c Configs, name string) int {
+	for i, v := range c.Customers {
+		if *v.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.toml")
+
+	conf, err := NewConfigs("./test_config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewAPIKeyMap(conf.Customers, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry(conf, keys, path, false, "")
+	changed := r.Changed()
+
+	key, name, db, hosts := "5ca1ab1e", "servicez", "telegraf3", []string{"http://127.0.0.1:8086"}
+	if err := r.Create(Config{APIKey: &key, Name: &name, InfluxDBName: &db, InfluxHosts: &hosts}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Errorf("Changed should be closed after a change")
+	}
+	if c, ok := r.Keys()[key]; !ok || c.Name != name || c.OutgoingQueueCap != 4096 {
+		t.Errorf("Created customer does not match. Got: %+v", c)
+	}
+	if err := r.Create(Config{APIKey: &key, Name: &name, InfluxDBName: &db, InfluxHosts: &hosts}); err != ErrCustomerExists {
+		t.Errorf("Got: %v, Expected: %v", err, ErrCustomerExists)
+	}
+	key2, name2, cap := "0ddba11", "servicew", -1
+	if err := r.Create(Config{APIKey: &key2, Name: &name2, InfluxDBName: &db, InfluxHosts: &hosts, OutgoingQueueCap: &cap}); err == nil {
+		t.Errorf("A negative queue cap should be rejected")
+	}
+	dots := ".."
+	if err := r.Create(Config{APIKey: &key2, Name: &dots, InfluxDBName: &db, InfluxHosts: &hosts}); err == nil {
+		t.Errorf("A customer named .. should be rejected")
+	}
+
+	usage := r.Keys()[key].Usage
+	db2 := "telegraf4"
+	if err := r.Update(name, Config{Name: &name, InfluxDBName: &db2, InfluxHosts: &hosts}); err != nil {
+		t.Fatal(err)
+	}
+	if c := r.Keys()[key]; c.InfluxDBName != db2 || c.Usage != usage {
+		t.Errorf("Updated customer should keep its api key and usage. Got: %+v", c)
+	}
+
+	if err := r.Delete("servicex"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete("servicex"); err != ErrCustomerNotFound {
+		t.Errorf("Got: %v, Expected: %v", err, ErrCustomerNotFound)
+	}
+	if r.Version() != 3 {
+		t.Errorf("Version Got: %d, Expected: 3", r.Version())
+	}
+
+	// The changes are saved to the state file and applied on top of the config file on startup.
+	loaded, err := LoadRegistry(conf, path, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := loaded.Snapshot()
+	if len(saved.Customers) != 2 || *saved.Customers[0].Name != "servicey" || *saved.Customers[1].InfluxDBName != db2 {
+		t.Errorf("Saved config does not match. Got: %d customers", len(saved.Customers))
+	}
+	if saved.Customers[0].Auth.Password != "password2" {
+		t.Errorf("Saved auth Got: %s, Expected: password2", saved.Customers[0].Auth.Password)
+	}
+	if _, ok := loaded.Keys()[key]; !ok {
+		t.Errorf("Created customer should be in the api key map")
+	}
+	var st State
+	if _, err := toml.DecodeFile(path, &st); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Customers) != 1 || *st.Customers[0].Name != name || len(st.Deleted) != 1 || st.Deleted[0] != "servicex" {
+		t.Errorf("Only the changes should be saved. Got: %d customers, deleted %v", len(st.Customers), st.Deleted)
+	}
+
+	// Changing a customer back to its config drops it from the state file.
+	if err := r.Create(conf.Customers[0]); err != nil {
+		t.Fatal(err)
+	}
+	st = State{}
+	if _, err := toml.DecodeFile(path, &st); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Deleted) != 0 {
+		t.Errorf("Deleted Got: %v, Expected: none", st.Deleted)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("State file should only be readable by the owner. Got: %v %v", fi.Mode(), err)
+	}
+
+	// An api key of the state file already used by a customer of the config file is rejected.
+	dup := "servicen"
+	if err := writeState(path, State{Customers: []Config{{APIKey: conf.Customers[1].APIKey, Name: &dup, InfluxDBName: &db, InfluxHosts: &hosts}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRegistry(conf, path, false, ""); err == nil {
+		t.Errorf("A duplicate api key in the state file should be rejected")
+	}
+}
+
+func TestRegistryKeepsRuntimeLimits(t *testing.T) {
+	r := NewRegistry(&Configs{}, APIKeyMap{}, "", false, "")
+	key, name, db, hosts, rate := "5ca1ab1e", "servicez", "telegraf3", []string{"http://127.0.0.1:8086"}, 10
+	if err := r.Create(Config{APIKey: &key, Name: &name, InfluxDBName: &db, InfluxHosts: &hosts, WriteBatchRateLimit: &rate}); err != nil {
+		t.Fatal(err)
+	}
+	limiter := r.Keys()[key].WriteLimiter
+	l := limiter.Limits()
+	l.ByteRate, l.DailyBytes = 1000, 5000
+	limiter.SetLimits(l)
+
+	db2 := "telegraf4"
+	if err := r.Update(name, Config{Name: &name, InfluxDBName: &db2, InfluxHosts: &hosts, WriteBatchRateLimit: &rate}); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Keys()[key].WriteLimiter.Limits(); got != l {
+		t.Errorf("Runtime limits should be kept. Got: %+v, Expected: %+v", got, l)
+	}
+
+	rate2 := 20
+	if err := r.Update(name, Config{Name: &name, InfluxDBName: &db2, InfluxHosts: &hosts, WriteBatchRateLimit: &rate2}); err != nil {
+		t.Fatal(err)
+	}
+	got := r.Keys()[key].WriteLimiter.Limits()
+	if got.BatchRate != 20 || got.BatchBurst != 20 || got.ByteRate != 1000 || got.DailyBytes != 5000 {
+		t.Errorf("Only the changed limits should be set. Got: %+v", got)
+	}
+}
@@ -0,0 +1,221 @@
+// Package config handles the configurations etc.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/samitpal/influxdb-router/backends"
+)
+
+// Secrets that can be revealed.
+const (
+	SecretAPIKey       = "api_key"
+	SecretAuthPassword = "auth_password"
+)
+
+// CustomerView is the config of a customer with the effective defaults and the secrets masked.
+type CustomerView struct {
+	APIKey              string         `json:"api_key"`
+	Name                string         `json:"name"`
+	Email               string         `json:"email"`
+	Paused              bool           `json:"paused"`
+	InfluxHosts         []string       `json:"influx_hosts"`
+	InfluxDBName        string         `json:"influx_db_name"`
+	OutgoingQueueCap    int            `json:"outgoing_queue_cap"`
+	RetryQueueCap       int            `json:"retry_queue_cap"`
+	IncomingQueueCap    int            `json:"incoming_queue_cap"`
+	Weight              int            `json:"weight"`
+	Priority            int            `json:"priority"`
+	IncomingQueueBytes  int64          `json:"incoming_queue_bytes"`
+	OutgoingQueueBytes  int64          `json:"outgoing_queue_bytes"`
+	RetryQueueBytes     int64          `json:"retry_queue_bytes"`
+	QueryRateLimit      int            `json:"query_rate_limit"`
+	QueryBurst          int            `json:"query_burst"`
+	QueryConcurrency    int            `json:"query_concurrency"`
+	WriteBatchRateLimit int            `json:"write_batch_rate_limit"`
+	WriteBatchBurst     int            `json:"write_batch_burst"`
+	WriteBytesRateLimit int            `json:"write_bytes_rate_limit"`
+	WriteBytesBurst     int            `json:"write_bytes_burst"`
+	DailyBytesQuota     int64          `json:"daily_bytes_quota"`
+	DailyPointsQuota    int64          `json:"daily_points_quota"`
+	Auth                Authentication `json:"auth"`
+	Backends            []BackendView  `json:"backends"`
+}
+
+// CustomerInput is the config of a customer sent to the api to create or update it. The fields that are not
+// set take their default, but for the api key and the auth which are kept on an update.
+type CustomerInput struct {
+	APIKey              *string         `json:"api_key,omitempty"`
+	Name                *string         `json:"name,omitempty"`
+	Email               *string         `json:"email,omitempty"`
+	InfluxHosts         *[]string       `json:"influx_hosts,omitempty"`
+	InfluxDBName        *string         `json:"influx_db_name,omitempty"`
+	OutgoingQueueCap    *int            `json:"outgoing_queue_cap,omitempty"`
+	RetryQueueCap       *int            `json:"retry_queue_cap,omitempty"`
+	IncomingQueueCap    *int            `json:"incoming_queue_cap,omitempty"`
+	Weight              *int            `json:"weight,omitempty"`
+	Priority            *int            `json:"priority,omitempty"`
+	IncomingQueueBytes  *int64          `json:"incoming_queue_bytes,omitempty"`
+	OutgoingQueueBytes  *int64          `json:"outgoing_queue_bytes,omitempty"`
+	RetryQueueBytes     *int64          `json:"retry_queue_bytes,omitempty"`
+	QueryRateLimit      *int            `json:"query_rate_limit,omitempty"`
+	QueryBurst          *int            `json:"query_burst,omitempty"`
+	QueryConcurrency    *int            `json:"query_concurrency,omitempty"`
+	WriteBatchRateLimit *int            `json:"write_batch_rate_limit,omitempty"`
+	WriteBatchBurst     *int            `json:"write_batch_burst,omitempty"`
+	WriteBytesRateLimit *int            `json:"write_bytes_rate_limit,omitempty"`
+	WriteBytesBurst     *int            `json:"write_bytes_burst,omitempty"`
+	DailyBytesQuota     *int64          `json:"daily_bytes_quota,omitempty"`
+	DailyPointsQuota    *int64          `json:"daily_points_quota,omitempty"`
+	Auth                *Authentication `json:"auth,omitempty"`
+}
+
+// Config returns the toml config of the customer. The secrets masked by CustomerView are rejected, so
+// that a view sent back does not replace them with their masks.
+func (in CustomerInput) Config() (Config, error) {
+	if in.APIKey != nil && Masked(*in.APIKey) {
+		return Config{}, fmt.Errorf("api_key is masked, leave it out to keep the current one")
+	}
+	if in.Auth != nil && Masked(in.Auth.Password) {
+		return Config{}, fmt.Errorf("auth.password is masked, leave auth out to keep the current one")
+	}
+	return Config{
+		APIKey:              in.APIKey,
+		Name:                in.Name,
+		Email:               in.Email,
+		InfluxHosts:         in.InfluxHosts,
+		InfluxDBName:        in.InfluxDBName,
+		OutgoingQueueCap:    in.OutgoingQueueCap,
+		RetryQueueCap:       in.RetryQueueCap,
+		IncomingQueueCap:    in.IncomingQueueCap,
+		Weight:              in.Weight,
+		Priority:            in.Priority,
+		IncomingQueueBytes:  in.IncomingQueueBytes,
+		OutgoingQueueBytes:  in.OutgoingQueueBytes,
+		RetryQueueBytes:     in.RetryQueueBytes,
+		QueryRateLimit:      in.QueryRateLimit,
+		QueryBurst:          in.QueryBurst,
+		QueryConcurrency:    in.QueryConcurrency,
+		WriteBatchRateLimit: in.WriteBatchRateLimit,
+		WriteBatchBurst:     in.WriteBatchBurst,
+		WriteBytesRateLimit: in.WriteBytesRateLimit,
+		WriteBytesBurst:     in.WriteBytesBurst,
+		DailyBytesQuota:     in.DailyBytesQuota,
+		DailyPointsQuota:    in.DailyPointsQuota,
+		Auth:                in.Auth,
+	}, nil
+}
+
+// BackendView is a backend of a customer with the settings of its health check.
+type BackendView struct {
+	URL         string                  `json:"url"`
+	HealthCheck backends.HealthSettings `json:"health_check"`
+}
+
+// View returns the config of the customers with the secrets masked. The influxdb credentials and the
+// backends are the ones in use, from the api key map.
+func (c *Configs) View(ac APIKeyMap) []CustomerView {
+	views := make([]CustomerView, 0, len(c.Customers))
+	for _, r := range c.Customers {
+		v := CustomerView{
+			APIKey:              Redact(*r.APIKey),
+			Name:                *r.Name,
+			Email:               *r.Email,
+			InfluxHosts:         *r.InfluxHosts,
+			InfluxDBName:        *r.InfluxDBName,
+			OutgoingQueueCap:    *r.OutgoingQueueCap,
+			RetryQueueCap:       *r.RetryQueueCap,
+			IncomingQueueCap:    *r.IncomingQueueCap,
+			Weight:              *r.Weight,
+			Priority:            *r.Priority,
+			IncomingQueueBytes:  *r.IncomingQueueBytes,
+			OutgoingQueueBytes:  *r.OutgoingQueueBytes,
+			RetryQueueBytes:     *r.RetryQueueBytes,
+			QueryRateLimit:      *r.QueryRateLimit,
+			QueryBurst:          *r.QueryBurst,
+			QueryConcurrency:    *r.QueryConcurrency,
+			WriteBatchRateLimit: *r.WriteBatchRateLimit,
+			WriteBatchBurst:     *r.WriteBatchBurst,
+			WriteBytesRateLimit: *r.WriteBytesRateLimit,
+			WriteBytesBurst:     *r.WriteBytesBurst,
+			DailyBytesQuota:     *r.DailyBytesQuota,
+			DailyPointsQuota:    *r.DailyPointsQuota,
+			Backends:            []BackendView{},
+		}
+		if a, ok := ac[*r.APIKey]; ok {
+			v.Paused = a.Pause.Paused()
+		}
+		user, password := r.creds(ac)
+		v.Auth = Authentication{UserName: user, Password: Redact(password)}
+		for _, d := range ac[*r.APIKey].Dests {
+			v.Backends = append(v.Backends, BackendView{URL: d.URL, HealthCheck: d.HealthSettings()})
+		}
+		sort.Slice(v.Backends, func(i, j int) bool { return v.Backends[i].URL < v.Backends[j].URL })
+		views = append(views, v)
+	}
+	return views
+}
+
+// creds returns the influxdb credentials in use, the ones of the toml config if auth is not enabled.
+func (r Config) creds(ac APIKeyMap) (string, string) {
+	if a, ok := ac[*r.APIKey]; ok && (a.InfluxDBUserName != "" || a.InfluxDBPassword != "") {
+		return a.InfluxDBUserName, a.InfluxDBPassword
+	}
+	return r.Auth.UserName, r.Auth.Password
+}
+
+// Secret returns a secret of the customer with the given name in clear text.
+// The second value is false if there is no such customer or secret.
+func (c *Configs) Secret(ac APIKeyMap, name string, secret string) (string, bool) {
+	for _, r := range c.Customers {
+		if *r.Name != name {
+			continue
+		}
+		switch secret {
+		case SecretAPIKey:
+			return *r.APIKey, true
+		case SecretAuthPassword:
+			_, password := r.creds(ac)
+			return password, true
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// Redact masks a secret like Mask, all of it if it is too short to show its end.
+func Redact(s string) string {
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+	return Mask(s, 4)
+}
+
+// Masked tells whether s looks like a secret masked by Redact.
+func Masked(s string) bool {
+	stars := len(s) - len(strings.TrimLeft(s, "*"))
+	return stars > 0 && (stars == len(s) || (len(s) > 8 && stars == len(s)-4))
+}
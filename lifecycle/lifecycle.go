@@ -0,0 +1,315 @@
+// Package lifecycle records what happens to every batch going through the router.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lifecycle
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samitpal/influxdb-router/logging"
+)
+
+var log = logging.For("lifecycle")
+
+// Lifecycle events of a batch.
+const (
+	Received    = "received"     // the request was accepted by the listener
+	Rejected    = "rejected"     // the request was refused, e.g. by the rate limits
+	Queued      = "queued"       // put in the incoming queue, or in the out going queue of a backend
+	RetryQueued = "retry_queued" // put in the retry queue of a backend
+	Written     = "written"      // written to a backend
+	WriteFailed = "write_failed" // the write to a backend failed
+	Dropped     = "dropped"      // a queue was full
+	Spilled     = "spilled"      // written to disk on shutdown
+)
+
+// Event is a lifecycle event of a batch.
+type Event struct {
+	Time      time.Time `json:"time"`
+	MessageID string    `json:"message_id"`
+	Customer  string    `json:"customer"`
+	Backend   string    `json:"backend,omitempty"`
+	Event     string    `json:"event"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Journal keeps the last events in a ring buffer and optionally appends them to a file.
+// The file is written by a goroutine of its own, Record does no I/O. A nil *Journal records nothing.
+type Journal struct {
+	sync.Mutex
+	events   []Event
+	next     int
+	full     bool
+	recorded uint64 // events added to the ring buffer
+	path     string
+	pending  chan pendingEvent // events to append to the file, nil without a file
+	flush    chan chan error
+	lost     uint64 // events not appended to the file because pending was full, updated atomically
+
+	// Owned by the goroutine writing the file.
+	file      *os.File
+	w         *bufio.Writer
+	written   int    // events appended to the file since it was last compacted
+	compacted uint64 // events of the ring buffer saved by the last compaction
+}
+
+// pendingEvent is an event waiting to be appended to the file, with its rank in the ring buffer.
+type pendingEvent struct {
+	Event
+	seq uint64
+}
+
+// maxPending is the maximum number of events waiting to be appended to the file. The events recorded
+// while it is full are only saved by the next compaction, if they are still in the ring buffer.
+const maxPending = 10000
+
+// Default is the journal used by Record, nil till it is enabled.
+var Default *Journal
+
+// NewJournal returns a *Journal keeping the last size events. If path is not empty the events are
+// appended to it and the last size events saved in it are loaded.
+func NewJournal(size int, path string) (*Journal, error) {
+	j := &Journal{events: make([]Event, size), path: path}
+	if path == "" {
+		return j, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var e Event
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				continue
+			}
+			j.add(e)
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	j.pending, j.flush = make(chan pendingEvent, maxPending), make(chan chan error)
+	go j.write()
+	return j, nil
+}
+
+// Record records an event of the default journal.
+func Record(messageID string, customer string, backend string, event string, reason string) {
+	Default.Record(messageID, customer, backend, event, reason)
+}
+
+// Record records an event. The event is appended to the file in the background, it is only kept in memory if
+// the file writer is behind.
+func (j *Journal) Record(messageID string, customer string, backend string, event string, reason string) {
+	if j == nil {
+		return
+	}
+	e := Event{Time: time.Now().UTC(), MessageID: messageID, Customer: customer, Backend: backend, Event: event, Reason: reason}
+	j.Lock()
+	defer j.Unlock()
+	j.add(e)
+	if j.pending == nil {
+		return
+	}
+	select {
+	case j.pending <- pendingEvent{Event: e, seq: j.recorded}:
+	default:
+		atomic.AddUint64(&j.lost, 1)
+	}
+}
+
+func (j *Journal) add(e Event) {
+	j.events[j.next] = e
+	j.next++
+	j.recorded++
+	if j.next == len(j.events) {
+		j.next = 0
+		j.full = true
+	}
+}
+
+// all returns the events of the ring buffer, oldest first.
+func (j *Journal) all() []Event {
+	if !j.full {
+		return append([]Event(nil), j.events[:j.next]...)
+	}
+	return append(append([]Event(nil), j.events[j.next:]...), j.events[:j.next]...)
+}
+
+// write appends the recorded events to the file and flushes it when asked to.
+func (j *Journal) write() {
+	for {
+		select {
+		case e := <-j.pending:
+			j.append(e)
+		case done := <-j.flush:
+			// Append the events recorded before the flush.
+			for n := len(j.pending); n > 0; n-- {
+				j.append(<-j.pending)
+			}
+			if lost := atomic.SwapUint64(&j.lost, 0); lost > 0 {
+				log.Errorf("%d events were not appended to %s, the writer was behind", lost, j.path)
+			}
+			var err error
+			if j.w != nil {
+				err = j.w.Flush()
+			}
+			done <- err
+		}
+	}
+}
+
+// append appends an event to the file, and compacts the file to keep it about the size of the ring buffer.
+func (j *Journal) append(e pendingEvent) {
+	if e.seq <= j.compacted {
+		// Saved by the compaction.
+		return
+	}
+	if j.w != nil {
+		b, _ := json.Marshal(e.Event)
+		j.w.Write(append(b, '\n'))
+	}
+	if j.written++; j.written >= 2*len(j.events) {
+		if err := j.compact(); err != nil {
+			log.Errorf("Error compacting %s: %v", j.path, err)
+		}
+	}
+}
+
+// compact rewrites the file with the events of the ring buffer and reopens it for appending.
+// The ring buffer is copied under the lock, the file is written without it.
+func (j *Journal) compact() error {
+	if j.file != nil {
+		j.w.Flush()
+		j.file.Close()
+		j.file, j.w = nil, nil
+	}
+	j.written = 0
+
+	j.Lock()
+	events, seq := j.all(), j.recorded
+	j.Unlock()
+
+	var buf []byte
+	for _, e := range events {
+		b, _ := json.Marshal(e)
+		buf = append(append(buf, b...), '\n')
+	}
+	if err := ioutil.WriteFile(j.path+".tmp", buf, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(j.path+".tmp", j.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.file, j.w, j.compacted = f, bufio.NewWriter(f), seq
+	return nil
+}
+
+// Flush writes the events recorded so far to the file.
+func (j *Journal) Flush() error {
+	if j == nil || j.flush == nil {
+		return nil
+	}
+	done := make(chan error)
+	j.flush <- done
+	return <-done
+}
+
+// Run flushes the file every interval.
+func (j *Journal) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := j.Flush(); err != nil {
+			log.Errorf("Error writing %s: %v", j.path, err)
+		}
+	}
+}
+
+// Timeline returns the events of a batch, oldest first.
+func (j *Journal) Timeline(messageID string) []Event {
+	j.Lock()
+	defer j.Unlock()
+	var events []Event
+	for _, e := range j.all() {
+		if e.MessageID == messageID {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Message is the timeline of a batch.
+type Message struct {
+	MessageID string  `json:"message_id"`
+	Customer  string  `json:"customer"`
+	Events    []Event `json:"events"`
+}
+
+// Search returns the timelines of up to limit batches of a customer (all the customers if empty)
+// received in [from, to), oldest first.
+func (j *Journal) Search(customer string, from time.Time, to time.Time, limit int) []Message {
+	j.Lock()
+	events := j.all()
+	j.Unlock()
+
+	byID := make(map[string]*Message)
+	var ids []string
+	for _, e := range events {
+		m, ok := byID[e.MessageID]
+		if !ok {
+			if (customer != "" && e.Customer != customer) || e.Time.Before(from) || !e.Time.Before(to) {
+				continue
+			}
+			m = &Message{MessageID: e.MessageID, Customer: e.Customer}
+			byID[e.MessageID] = m
+			ids = append(ids, e.MessageID)
+		}
+		m.Events = append(m.Events, e)
+	}
+
+	sort.SliceStable(ids, func(a, b int) bool { return byID[ids[a]].Events[0].Time.Before(byID[ids[b]].Events[0].Time) })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	messages := make([]Message, 0, len(ids))
+	for _, id := range ids {
+		messages = append(messages, *byID[id])
+	}
+	return messages
+}
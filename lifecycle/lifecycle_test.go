@@ -0,0 +1,94 @@
+package lifecycle
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "messages.jsonl")
+
+	j, err := NewJournal(4, path)
+	if err != nil {
+		t.Fatalf("Error creating the journal: %v", err)
+	}
+	j.Record("m1", "servicex", "", Received, "")
+	j.Record("m1", "servicex", "", Queued, "")
+	j.Record("m2", "servicey", "", Received, "")
+	j.Record("m1", "servicex", "http://127.0.0.1:8086", Written, "")
+	j.Record("m2", "servicey", "", Dropped, "incoming_queue_full")
+
+	// The oldest event is overwritten.
+	events := j.Timeline("m1")
+	if len(events) != 2 || events[0].Event != Queued || events[1].Backend != "http://127.0.0.1:8086" {
+		t.Errorf("Timeline does not match. Got: %v", events)
+	}
+
+	messages := j.Search("servicey", time.Time{}, time.Now().Add(time.Minute), 10)
+	if len(messages) != 1 || messages[0].MessageID != "m2" || len(messages[0].Events) != 2 {
+		t.Errorf("Search does not match. Got: %v", messages)
+	}
+	if messages := j.Search("", time.Time{}, time.Now().Add(time.Minute), 1); len(messages) != 1 || messages[0].MessageID != "m1" {
+		t.Errorf("Search should be limited. Got: %v", messages)
+	}
+
+	// The events survive a restart.
+	if err := j.Flush(); err != nil {
+		t.Fatalf("Error flushing the journal: %v", err)
+	}
+	j, err = NewJournal(4, path)
+	if err != nil {
+		t.Fatalf("Error loading the journal: %v", err)
+	}
+	if events := j.Timeline("m2"); len(events) != 2 || events[1].Reason != "incoming_queue_full" {
+		t.Errorf("Loaded timeline does not match. Got: %v", events)
+	}
+
+	var nilJournal *Journal
+	nilJournal.Record("m3", "servicex", "", Received, "")
+}
+
+func TestJournalCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "messages.jsonl")
+
+	j, err := NewJournal(4, path)
+	if err != nil {
+		t.Fatalf("Error creating the journal: %v", err)
+	}
+	for i := 1; i <= 21; i++ {
+		j.Record(fmt.Sprintf("m%d", i), "servicex", "", Received, "")
+	}
+	if err := j.Flush(); err != nil {
+		t.Fatalf("Error flushing the journal: %v", err)
+	}
+
+	// The file is kept about the size of the ring buffer, without the events saved by the compaction twice.
+	b, _ := ioutil.ReadFile(path)
+	if lines := bytes.Count(b, []byte("\n")); lines < 4 || lines >= 3*4 {
+		t.Errorf("The file should be compacted. Got: %d lines", lines)
+	}
+	j, err = NewJournal(4, path)
+	if err != nil {
+		t.Fatalf("Error loading the journal: %v", err)
+	}
+	for i := 18; i <= 21; i++ {
+		if events := j.Timeline(fmt.Sprintf("m%d", i)); len(events) != 1 {
+			t.Errorf("Loaded timeline of m%d does not match. Got: %v", i, events)
+		}
+	}
+}
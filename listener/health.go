@@ -0,0 +1,178 @@
+// Package listener provides code for managing incoming http requests.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package listener
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Check is the result of a readiness check.
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// healthReport is the json representation of the readiness of the router.
+type healthReport struct {
+	Status string  `json:"status"`
+	Checks []Check `json:"checks"`
+}
+
+// ReadyOptions configure the checks of the readiness of the router.
+type ReadyOptions struct {
+	MaxQueueRatio   float64 // fails when the incoming queues are fuller than this ratio of their cap, 0 disables
+	HealthyBackends bool    // fails when a customer has no healthy backend
+}
+
+// shuttingDown tells whether the HealthCheck channel was closed.
+func shuttingDown(httpConfig *HTTPListenerConfig) bool {
+	select {
+	case <-httpConfig.HealthCheck:
+		return true
+	default:
+		return false
+	}
+}
+
+// readinessChecks runs the checks of the readiness of the router.
+func readinessChecks(httpConfig *HTTPListenerConfig) []Check {
+	checks := []Check{{Name: "shutdown", OK: !shuttingDown(httpConfig)}}
+	if !checks[0].OK {
+		checks[0].Message = "shutting down"
+	}
+
+	writer := Check{Name: "writer", OK: true}
+	if httpConfig.WriterReady != nil {
+		select {
+		case <-httpConfig.WriterReady:
+		default:
+			writer.OK, writer.Message = false, "writer not started"
+		}
+	}
+	checks = append(checks, writer)
+
+	if r := httpConfig.Ready.MaxQueueRatio; r > 0 && httpConfig.Ingress != nil {
+		n, max := httpConfig.Ingress.Len(), float64(httpConfig.Ingress.Cap)*r
+		c := Check{Name: "incoming_queue", OK: float64(n) < max}
+		if !c.OK {
+			c.Message = fmt.Sprintf("%d batches queued, threshold %.0f", n, max)
+		}
+		checks = append(checks, c)
+	}
+
+	if httpConfig.Ready.HealthyBackends {
+		var down []string
+		for _, customer := range httpConfig.Customers.Keys() {
+			healthy := len(customer.Dests) == 0
+			for _, d := range customer.Dests {
+				if d.GetHealth() {
+					healthy = true
+					break
+				}
+			}
+			if !healthy {
+				down = append(down, customer.Name)
+			}
+		}
+		sort.Strings(down)
+		c := Check{Name: "backends", OK: len(down) == 0}
+		if !c.OK {
+			c.Message = "no healthy backend for " + strings.Join(down, ", ")
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// livez is a handler telling that the process is up.
+func livez(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "Ok")
+}
+
+// verbose tells whether a request asks for the details of the checks.
+func verbose(req *http.Request) bool {
+	v := req.URL.Query().Get("verbose")
+	return v != "" && v != "0" && v != "false"
+}
+
+// health is a handler to respond to load balancer health checks. It only fails on shutdown, so that a customer
+// whose backends are all down does not take every router out of rotation. With verbose=1 the readiness checks
+// are shown as json, they do not change the status code.
+func health(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerConfig) {
+	code, status := http.StatusOK, "ok"
+	if shuttingDown(httpConfig) {
+		code, status = http.StatusServiceUnavailable, "unavailable"
+	}
+	if verbose(req) {
+		writeReport(w, code, healthReport{Status: status, Checks: readinessChecks(httpConfig)})
+		return
+	}
+	w.WriteHeader(code)
+	if code != http.StatusOK {
+		io.WriteString(w, "Service Unavailable")
+		return
+	}
+	io.WriteString(w, "Ok")
+}
+
+// ready is a handler to respond to readiness probes, it fails when a readiness check fails.
+// The checks are shown as json with verbose=1.
+func ready(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerConfig) {
+	checks := readinessChecks(httpConfig)
+	code, status := http.StatusOK, "ok"
+	var failed []string
+	for _, c := range checks {
+		if !c.OK {
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) > 0 {
+		code, status = http.StatusServiceUnavailable, "unavailable"
+	}
+
+	if verbose(req) {
+		writeReport(w, code, healthReport{Status: status, Checks: checks})
+		return
+	}
+	w.WriteHeader(code)
+	if len(failed) > 0 {
+		io.WriteString(w, "Service Unavailable: "+strings.Join(failed, ", "))
+		return
+	}
+	io.WriteString(w, "Ok")
+}
+
+// writeReport writes the json representation of the checks.
+func writeReport(w http.ResponseWriter, code int, r healthReport) {
+	data, _ := json.Marshal(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
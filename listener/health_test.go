@@ -0,0 +1,87 @@
+package listener
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+func TestHealth(t *testing.T) {
+	d := backends.NewBackendDest("http://127.0.0.1:8086", 10, 10)
+	keys := config.APIKeyMap{"k": {Name: "a", Dests: map[string]*backends.BackendDest{d.URL: d}}}
+	writerReady, healthCheck := make(chan struct{}), make(chan struct{})
+	httpConfig := &HTTPListenerConfig{
+		Ingress:     backends.NewIngress(10),
+		Customers:   config.NewRegistry(&config.Configs{}, keys, "", false, ""),
+		HealthCheck: healthCheck,
+		WriterReady: writerReady,
+		Ready:       ReadyOptions{MaxQueueRatio: 0.5, HealthyBackends: true},
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if req.URL.Path == "/health" {
+			health(w, req, httpConfig)
+		} else {
+			ready(w, req, httpConfig)
+		}
+		return w
+	}
+
+	w := get("/health?verbose=1")
+	var r healthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	failed := map[string]bool{}
+	for _, c := range r.Checks {
+		failed[c.Name] = !c.OK
+	}
+	if w.Code != http.StatusOK || !failed["writer"] || !failed["backends"] || failed["incoming_queue"] || failed["shutdown"] {
+		t.Errorf("Writer and backends checks should fail without failing health. Got: %d %+v", w.Code, r)
+	}
+	if w := get("/readyz?verbose=1"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Readiness should fail. Got: %d", w.Code)
+	}
+	if w := get("/health"); w.Code != http.StatusOK || w.Body.String() != "Ok" {
+		t.Errorf("Health should only fail on shutdown. Got: %d %s", w.Code, w.Body.String())
+	}
+
+	close(writerReady)
+	d.SetHealth(true)
+	if w := get("/readyz"); w.Code != http.StatusOK || w.Body.String() != "Ok" {
+		t.Errorf("Router should be ready. Got: %d %s", w.Code, w.Body.String())
+	}
+
+	close(healthCheck)
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable || w.Body.String() != "Service Unavailable: shutdown" {
+		t.Errorf("Router should not be ready on shutdown. Got: %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Router should stay not ready on shutdown. Got: %d", w.Code)
+	}
+	if w := get("/health"); w.Code != http.StatusServiceUnavailable || w.Body.String() != "Service Unavailable" {
+		t.Errorf("Health should fail on shutdown. Got: %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/health?verbose=1"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Verbose health should fail on shutdown. Got: %d", w.Code)
+	}
+
+	// Concurrent probes all see the shutdown.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !shuttingDown(httpConfig) {
+				t.Errorf("Every probe should see the shutdown")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -26,53 +26,82 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/rs/xid"
 	"github.com/samitpal/influxdb-router/backends"
 	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/lifecycle"
 	"github.com/samitpal/influxdb-router/logging"
 	"github.com/samitpal/influxdb-router/stats"
+	"github.com/samitpal/influxdb-router/tail"
+	"github.com/samitpal/influxdb-router/tracing"
+	"github.com/samitpal/influxdb-router/usage"
+	"github.com/samitpal/influxdb-router/writer"
 )
 
 type messageContext string
 
-const messageContextKey = messageContext("messageBatchId")
+const (
+	messageContextKey = messageContext("messageBatchId")
+	spanContextKey    = messageContext("span")
+)
 
 var log = logging.For("listener")
 
+// pausedRetryAfter is the number of seconds a paused customer is asked to wait before retrying.
+const pausedRetryAfter = 60
+
 // HTTPListenerConfig holds configs for the http daemon
 type HTTPListenerConfig struct {
-	Addr              string
-	HTTPPort          string
-	HTTPSPort         string
-	IncomingQueue     chan *backends.Payload
-	Secure            bool
-	SSLCAServerCert   string
-	SSLServerCert     string
-	SSLServerKey      string
-	SSLClientCertAuth bool
-	APIKeyHeaderName  string
-	APIConfig         config.APIKeyMap
-	HealthCheck       chan bool
-	Statsd            *stats.Statsd
+	Addr               string
+	HTTPPort           string
+	HTTPSPort          string
+	Ingress            *backends.Ingress
+	Secure             bool
+	SSLCAServerCert    string
+	SSLServerCert      string
+	SSLServerKey       string
+	SSLClientCertAuth  bool
+	APIKeyHeaderName   string
+	Customers          *config.Registry
+	HealthCheck        <-chan struct{} // closed on shutdown to fail the health checks
+	WriterReady        <-chan struct{} // closed once the writer is started
+	Ready              ReadyOptions
+	QueryTimeout       int // Timeout in seconds for queries proxied to the backends
+	QueryCacheTTL      int // Time in seconds query responses are cached, 0 disables the cache
+	QueryCacheMaxBytes int // Max memory used by the cached query responses
+	UsageStore         *usage.Store
+	MaxBatchBytes      int64 // Max uncompressed size of a batch, usage.DefaultMaxBatchBytes if 0
 }
 
 // httpHandlers has all the routes defined.
 func httpHandlers(h *http.ServeMux, config *HTTPListenerConfig) *http.ServeMux {
-	h.Handle("/write", logHTTPRequest(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { ingest(w, req, config) })))
+	h.Handle("/write", logHTTPRequest(config, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { ingest(w, req, config) })))
+
+	proxy := &queryProxy{
+		client: &http.Client{Timeout: time.Duration(config.QueryTimeout) * time.Second},
+		cache:  newQueryCache(time.Duration(config.QueryCacheTTL)*time.Second, config.QueryCacheMaxBytes),
+	}
+	h.Handle("/query", logHTTPRequest(config, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { query(w, req, config, proxy) })))
 
-	h.Handle("/health", logHTTPRequest(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { health(w, config) })))
+	h.Handle("/livez", logHTTPRequest(config, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { livez(w) })))
+	h.Handle("/readyz", logHTTPRequest(config, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { ready(w, req, config) })))
+	h.Handle("/health", logHTTPRequest(config, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { health(w, req, config) })))
 	return h
 }
 
-func logHTTPRequest(next http.Handler) http.Handler {
+// logHTTPRequest traces a request and writes it to the access log once it is served.
+func logHTTPRequest(httpConfig *HTTPListenerConfig, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
 		host, _, err := net.SplitHostPort(req.RemoteAddr)
 
 		if err != nil {
@@ -80,7 +109,7 @@ func logHTTPRequest(next http.Handler) http.Handler {
 		}
 
 		method := req.Method
-		apiKey := req.Header.Get("Service-API-Key")
+		apiKey := apiKeyFromRequest(req, httpConfig.APIKeyHeaderName)
 		proto := req.Proto
 		userAgent := req.UserAgent()
 		var request string
@@ -94,15 +123,79 @@ func logHTTPRequest(next http.Handler) http.Handler {
 		mid := xid.New()
 		ctx := context.WithValue(req.Context(), messageContextKey, mid.String())
 
-		log.Printf("Received message-id: %s, remote-host: %s, uri: %s, http-method: %s, api-key: %s, http-proto: %s, user-agent: %s", mid, host, request, method, config.Mask(apiKey, 4), proto, userAgent)
-		next.ServeHTTP(w, req.WithContext(ctx))
+		// Continue the trace of the client if the request has a traceparent header.
+		parent, _ := tracing.ParseTraceparent(req.Header.Get("traceparent"))
+		span := tracing.Start(method+" "+req.URL.Path, tracing.KindServer, parent)
+		span.SetAttribute("message_id", mid.String())
+		span.SetAttribute("http.method", method)
+		span.SetAttribute("http.target", req.URL.Path)
+		ctx = context.WithValue(ctx, spanContextKey, span)
+
+		// The message-id lets the client look the batch up in the messages api.
+		w.Header().Set("X-Request-Id", mid.String())
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, req.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", strconv.Itoa(sw.code))
+		if sw.code >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(sw.code))
+		}
+		span.Finish()
+
+		logging.Access.WithFields(logrus.Fields{
+			"name":           "access",
+			"message_id":     mid.String(),
+			"remote_host":    host,
+			"method":         method,
+			"path":           req.URL.Path,
+			"uri":            request,
+			"proto":          proto,
+			"user_agent":     userAgent,
+			"api_key":        config.Mask(apiKey, 4),
+			"customer":       httpConfig.Customers.Keys()[apiKey].Name,
+			"status":         sw.code,
+			"request_bytes":  req.ContentLength,
+			"response_bytes": sw.bytes,
+			"latency_ms":     float64(time.Since(start).Nanoseconds()) / 1e6,
+		}).Info("request")
 	})
 }
 
+// statusWriter records the status code and the size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets streamed responses through.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// spanFromRequest returns the span of the request, nil if it is not traced.
+func spanFromRequest(req *http.Request) *tracing.Span {
+	span, _ := req.Context().Value(spanContextKey).(*tracing.Span)
+	return span
+}
+
 // HTTPListener accepts connections from a telegraf
 // client. Upon a successful client API key validation,
 // batches of compressed messages are passed to influxdb via http api.
-func HTTPListener(config *HTTPListenerConfig) {
+func HTTPListener(config *HTTPListenerConfig) *http.Server {
 	h := http.NewServeMux()
 	h = httpHandlers(h, config)
 
@@ -133,25 +226,28 @@ func HTTPListener(config *HTTPListenerConfig) {
 		go func() {
 			log.Infof("InfluxDB Router listening on https %s:%s\n", config.Addr, httpsPort)
 			err := srv.ListenAndServeTLS(config.SSLServerCert, config.SSLServerKey)
-			if err != nil {
+			if err != nil && err != http.ErrServerClosed {
 				log.Fatalf("ListenAndServeTLS: %s\n", err)
 			}
 		}()
-		//Run in http mode
-	} else {
-		httpPort := config.HTTPPort
-		go func() {
-			log.Infof("InfluxDB Router listening on http %s:%s\n", config.Addr, httpPort)
-			err := http.ListenAndServe(config.Addr+":"+httpPort, h)
-			if err != nil {
-				log.Fatalf("ListenAndServe: %s\n", err)
-			}
-		}()
+		return srv
 	}
+
+	//Run in http mode
+	httpPort := config.HTTPPort
+	srv := &http.Server{Addr: config.Addr + ":" + httpPort, Handler: h}
+	go func() {
+		log.Infof("InfluxDB Router listening on http %s:%s\n", config.Addr, httpPort)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServe: %s\n", err)
+		}
+	}()
+	return srv
 }
 
 // ingest is a handler that accepts a batch of compressed data points.
-// Each batch is then pushed to the IncomingQueue for downstream destination writing.
+// Each batch is then pushed to the customer's IncomingQueue for downstream destination writing.
 func ingest(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerConfig) {
 
 	// Validate key on every batch.
@@ -167,7 +263,8 @@ func ingest(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerCo
 	}
 
 	// Check if the api key that the request came with is valid.
-	_, valid := httpConfig.APIConfig[apiKey]
+	version := httpConfig.Customers.Version()
+	customer, valid := httpConfig.Customers.Keys()[apiKey]
 	if !valid {
 		log.Infof("[client %s, api-key: %s] Not a valid api key\n",
 			client, apiKey)
@@ -191,8 +288,24 @@ func ingest(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerCo
 	} else {
 		messageID = ""
 	}
+	span := spanFromRequest(req)
+	span.SetAttribute("customer", customer.Name)
+
+	lifecycle.Record(messageID, customer.Name, "", lifecycle.Received, "")
+
 	// counter metric by api key
-	go httpConfig.Statsd.SendStatsdCounterMetric(fmt.Sprintf("influx_router.%s.hits", strings.Replace(httpConfig.APIConfig[apiKey].Name, "-", "_", -1)), 1)
+	stats.Count("hits", stats.Tags{"customer": customer.Name}, 1)
+
+	// A paused customer is asked to come back later.
+	if customer.Pause.Paused() {
+		httpConfig.UsageStore.Add(customer.Name, customer.Email, usage.Counts{RejectedWrites: 1})
+		span.SetError("customer paused")
+		lifecycle.Record(messageID, customer.Name, "", lifecycle.Rejected, "customer paused")
+		stats.Count("paused_requests", stats.Tags{"customer": customer.Name}, 1)
+		w.Header().Set("Retry-After", strconv.Itoa(pausedRetryAfter))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 
 	buf, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -201,33 +314,87 @@ func ingest(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerCo
 	}
 
 	// batch (compressed) size counter metric by api key
-	go httpConfig.Statsd.SendStatsdCounterMetric(fmt.Sprintf("influx_router.%s.batch-size-bytes", strings.Replace(httpConfig.APIConfig[apiKey].Name, "-", "_", -1)), len(buf))
+	stats.Count("batch-size-bytes", stats.Tags{"customer": customer.Name}, float64(len(buf)))
 
-	p := backends.Payload{MessageID: messageID, Body: buf, APIKey: apiKey}
-	select {
-	case httpConfig.IncomingQueue <- &p: // Put the batch into the channel unless it is full
-		w.WriteHeader(http.StatusNoContent)
-		return
-	default:
-		w.WriteHeader(http.StatusOK)
-		log.Infof("[client-ip: %s, api-key: %s] IncomingQueue Queue full. Discarding batch.", client, config.Mask(apiKey, 4))
+	batch, err := usage.Parse(buf, httpConfig.MaxBatchBytes)
+	if err != nil {
+		httpConfig.UsageStore.Add(customer.Name, customer.Email, usage.Counts{RejectedWrites: 1})
+		span.SetError(err.Error())
+		lifecycle.Record(messageID, customer.Name, "", lifecycle.Rejected, err.Error())
+		log.Infof("[client-ip: %s, api-key: %s] Error decompressing batch: %v", client, config.Mask(apiKey, 4), err)
+		if err == usage.ErrTooLarge {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-}
+	points := int(batch.Points)
 
-// health is a handler to respond to load balancer health checks.
-func health(w http.ResponseWriter, httpConfig *HTTPListenerConfig) {
+	// Sample the batch for the tails of the customer, if any. It is only decompressed once it is known to
+	// be within the max size.
+	tail.Default.Publish(customer.Name, buf)
 
-	select {
-	// Fail lb health checks.
-	case <-httpConfig.HealthCheck:
-		// set the channel back to true so that subsequent health checks fail as well.
-		httpConfig.HealthCheck <- true
-		w.WriteHeader(http.StatusServiceUnavailable)
-		io.WriteString(w, "Service Unavailable")
-	default:
-		w.WriteHeader(http.StatusOK)
-		io.WriteString(w, "Ok")
+	// Enforce the write rate limits and daily quotas of the customer.
+	if ok, wait, reason := customer.WriteLimiter.Allow(len(buf), points); !ok {
+		httpConfig.UsageStore.Add(customer.Name, customer.Email, usage.Counts{RejectedWrites: 1})
+		span.SetError(reason)
+		lifecycle.Record(messageID, customer.Name, "", lifecycle.Rejected, reason)
+		stats.Count("write_throttled", stats.Tags{"customer": customer.Name, "reason": strings.Replace(reason, " ", "_", -1)}, 1)
+		log.Infof("[client-ip: %s, api-key: %s] Discarding batch: %s", client, config.Mask(apiKey, 4), reason)
+		w.Header().Set("Retry-After", retryAfter(wait))
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, reason)
+		return
 	}
 
+	span.SetAttribute("points", strconv.Itoa(points))
+	p := backends.Payload{MessageID: messageID, Body: buf, APIKey: apiKey, Points: points, Trace: span.SpanContext(), Received: time.Now()}
+	// Put the batch into the customer's incoming queue unless it or the ingress is full
+	if httpConfig.Ingress.Push(customer.IncomingQueue, &p) {
+		requeue(httpConfig, apiKey, customer, version)
+		// Only the batches that are queued count as ingested.
+		customer.Usage.Add(batch)
+		tags := stats.Tags{"customer": customer.Name}
+		stats.Count("points", tags, float64(batch.Points))
+		stats.Count("lines", tags, float64(batch.Lines))
+		stats.Count("fields", tags, float64(batch.Fields))
+		stats.Count("uncompressed_bytes", tags, float64(batch.UncompressedBytes))
+		httpConfig.UsageStore.Add(customer.Name, customer.Email, usage.Counts{
+			Batches:           1,
+			Points:            batch.Points,
+			Bytes:             batch.Bytes,
+			UncompressedBytes: batch.UncompressedBytes,
+		})
+		lifecycle.Record(messageID, customer.Name, "", lifecycle.Queued, "")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// The batch is not stored, it does not count against the limits of the customer.
+	customer.WriteLimiter.Refund(len(buf), points)
+	httpConfig.UsageStore.Add(customer.Name, customer.Email, usage.Counts{RejectedWrites: 1})
+	span.SetError("incoming queue full")
+	lifecycle.Record(messageID, customer.Name, "", lifecycle.Dropped, "incoming_queue_full")
+	stats.Count("dropped_batches", stats.Tags{"customer": customer.Name, "reason": "incoming_queue_full"}, 1)
+	w.WriteHeader(http.StatusOK)
+	log.Infof("[client-ip: %s, api-key: %s] IncomingQueue Queue full. Discarding batch.", client, config.Mask(apiKey, 4))
+}
+
+// requeue moves the batches of an incoming queue replaced since version to the new incoming queue of the
+// customer. The writer moves them when it follows the change, but not a batch pushed after it did.
+func requeue(httpConfig *HTTPListenerConfig, apiKey string, customer config.APIKeyConfig, version uint64) {
+	if httpConfig.Customers.Version() == version {
+		return
+	}
+	next, ok := httpConfig.Customers.Keys().Successor(apiKey, customer.Name)
+	if ok && next.IncomingQueue == customer.IncomingQueue {
+		return
+	}
+	for p := httpConfig.Ingress.Pop(customer.IncomingQueue); p != nil; p = httpConfig.Ingress.Pop(customer.IncomingQueue) {
+		if !ok {
+			writer.DropBatch(p, customer.Name, "", "customer_removed")
+		} else if !httpConfig.Ingress.Push(next.IncomingQueue, p) {
+			writer.DropBatch(p, customer.Name, "", "incoming_queue_full")
+		}
+	}
 }
@@ -0,0 +1,34 @@
+package listener
+
+import (
+	"testing"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+func TestRequeue(t *testing.T) {
+	key, name, db, hosts := "5ca1ab1e", "servicez", "telegraf3", []string{"http://127.0.0.1:8086"}
+	httpConfig := &HTTPListenerConfig{
+		Ingress:   backends.NewIngress(10),
+		Customers: config.NewRegistry(&config.Configs{}, config.APIKeyMap{}, "", false, ""),
+	}
+	if err := httpConfig.Customers.Create(config.Config{APIKey: &key, Name: &name, InfluxDBName: &db, InfluxHosts: &hosts}); err != nil {
+		t.Fatal(err)
+	}
+	version := httpConfig.Customers.Version()
+	customer := httpConfig.Customers.Keys()[key]
+
+	// The customer is renamed between the lookup of its config and the push of the batch.
+	renamed := "servicew"
+	if err := httpConfig.Customers.Update(name, config.Config{Name: &renamed, InfluxDBName: &db, InfluxHosts: &hosts}); err != nil {
+		t.Fatal(err)
+	}
+	httpConfig.Ingress.Push(customer.IncomingQueue, &backends.Payload{APIKey: key})
+	requeue(httpConfig, key, customer, version)
+
+	next := httpConfig.Customers.Keys()[key]
+	if httpConfig.Ingress.Pop(customer.IncomingQueue) != nil || httpConfig.Ingress.Pop(next.IncomingQueue) == nil {
+		t.Errorf("The batch should be moved to the new incoming queue of the customer")
+	}
+}
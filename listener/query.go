@@ -0,0 +1,474 @@
+// Package listener provides code for managing incoming http requests.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package listener
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/stats"
+	"github.com/samitpal/influxdb-router/tracing"
+	"github.com/samitpal/influxdb-router/usage"
+)
+
+// showAllowed lists the SHOW statements a customer may run against its own database.
+var showAllowed = map[string]bool{
+	"MEASUREMENTS": true,
+	"MEASUREMENT":  true,
+	"TAG":          true,
+	"FIELD":        true,
+	"SERIES":       true,
+	"RETENTION":    true,
+}
+
+// apiKeyFromRequest returns the customer api key of a query request. The key is looked up
+// in the api key header, then in an 'Authorization: Token <key>' header and finally in the
+// basic auth password, which is what Grafana sends.
+func apiKeyFromRequest(req *http.Request, headerName string) string {
+	if k := req.Header.Get(headerName); k != "" {
+		return k
+	}
+	if a := req.Header.Get("Authorization"); strings.HasPrefix(a, "Token ") {
+		return strings.TrimSpace(strings.TrimPrefix(a, "Token "))
+	}
+	if _, p, ok := req.BasicAuth(); ok {
+		return p
+	}
+	return ""
+}
+
+// queryError writes an error in the same json format as InfluxDB so that clients like Grafana display it.
+func queryError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// queryProxy holds what the query handler shares across requests.
+type queryProxy struct {
+	client *http.Client
+	cache  *queryCache
+}
+
+// query is a handler that proxies InfluxQL read queries to a healthy backend of the customer.
+// The db parameter is always forced to the customer's database and the statements are checked
+// so that they can not touch other databases or run admin commands. Responses are served from
+// the query cache when possible, cache misses are subject to the customer's query limits.
+func query(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerConfig, proxy *queryProxy) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		queryError(w, http.StatusMethodNotAllowed, "only GET and POST are supported")
+		return
+	}
+
+	apiKey := apiKeyFromRequest(req, httpConfig.APIKeyHeaderName)
+	conf, valid := httpConfig.Customers.Keys()[apiKey]
+	if !valid {
+		log.Infof("[client %s, api-key: %s] Not a valid api key\n", req.RemoteAddr, config.Mask(apiKey, 4))
+		queryError(w, http.StatusUnauthorized, "authorization failed")
+		return
+	}
+
+	if conf.Pause.Paused() {
+		stats.Count("paused_requests", stats.Tags{"customer": conf.Name}, 1)
+		w.Header().Set("Retry-After", strconv.Itoa(pausedRetryAfter))
+		queryError(w, http.StatusServiceUnavailable, "customer paused")
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		queryError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := req.Form.Get("q")
+	if q == "" {
+		queryError(w, http.StatusBadRequest, `missing required parameter "q"`)
+		return
+	}
+	// Bound parameters are substituted by the backends, after the checks.
+	if _, ok := req.Form["params"]; ok {
+		queryError(w, http.StatusForbidden, "bound parameters are not allowed")
+		return
+	}
+	if err := checkQuery(q, conf.InfluxDBName); err != nil {
+		log.Infof("[client %s, api-key: %s] Rejected query: %v", req.RemoteAddr, config.Mask(apiKey, 4), err)
+		queryError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	// Only the queries that pass the checks are billed.
+	httpConfig.UsageStore.Add(conf.Name, conf.Email, usage.Counts{Queries: 1})
+
+	params := url.Values{}
+	for k, v := range req.Form {
+		// Credentials of the client are never passed on to the backends.
+		if k == "u" || k == "p" {
+			continue
+		}
+		params[k] = v
+	}
+	params.Set("db", conf.InfluxDBName)
+
+	stats.Count("queries", stats.Tags{"customer": conf.Name}, 1)
+
+	// Chunked responses are streamed and never cached.
+	cacheable := proxy.cache != nil && params.Get("chunked") != "true"
+	var cacheKey string
+	if cacheable {
+		cacheKey = queryCacheKey(conf.Name, params)
+		if r, ok := proxy.cache.get(cacheKey); ok {
+			stats.Count("query_cache.hits", stats.Tags{"customer": conf.Name}, 1)
+			for k, v := range r.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(r.body)
+			return
+		}
+		stats.Count("query_cache.misses", stats.Tags{"customer": conf.Name}, 1)
+	}
+
+	if ok, wait := conf.QueryLimiter.Take(1); !ok {
+		stats.Count("query_throttled", stats.Tags{"customer": conf.Name, "reason": "rate_limit_exceeded"}, 1)
+		w.Header().Set("Retry-After", retryAfter(wait))
+		queryError(w, http.StatusTooManyRequests, "query rate limit exceeded")
+		return
+	}
+	if !conf.QuerySlots.TryAcquire() {
+		stats.Count("query_throttled", stats.Tags{"customer": conf.Name, "reason": "too_many_concurrent_queries"}, 1)
+		w.Header().Set("Retry-After", "1")
+		queryError(w, http.StatusTooManyRequests, "too many concurrent queries")
+		return
+	}
+	defer conf.QuerySlots.Release()
+
+	resp, err := forwardQuery(proxy.client, req.Method, params, conf, spanFromRequest(req).SpanContext())
+	if err != nil {
+		log.Errorf("[api-key: %s] Query failed: %v", config.Mask(apiKey, 4), err)
+		queryError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+
+	if !cacheable || resp.StatusCode != http.StatusOK {
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	// Read up to one byte more than what may be cached to find out whether the body fits.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(proxy.cache.maxEntryBytes())+1))
+	if err != nil {
+		log.Errorf("[api-key: %s] Error reading query response: %v", config.Mask(apiKey, 4), err)
+		queryError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+	if len(body) > proxy.cache.maxEntryBytes() {
+		io.Copy(w, resp.Body)
+		return
+	}
+	proxy.cache.set(cacheKey, resp.Header, body)
+}
+
+// queryCacheKey builds the cache key of a query from the customer name and the query parameters.
+func queryCacheKey(name string, params url.Values) string {
+	p := url.Values{}
+	for k, v := range params {
+		p[k] = v
+	}
+	p.Set("q", normalizeQuery(params.Get("q")))
+	return name + "\x00" + p.Encode()
+}
+
+// retryAfter formats a wait duration as the value of a Retry-After header (whole seconds, at least 1).
+func retryAfter(d time.Duration) string {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 1 {
+		s = 1
+	}
+	return strconv.Itoa(s)
+}
+
+// forwardQuery sends the query to the healthy backends of the customer one after the other
+// till one of them answers without a server error.
+// Every attempt is traced as a child of trace, which is propagated to the backend.
+func forwardQuery(client *http.Client, method string, params url.Values, conf config.APIKeyConfig, trace tracing.SpanContext) (*http.Response, error) {
+	var lastErr error
+	tried := 0
+	for _, d := range conf.Dests {
+		if !d.GetHealth() {
+			continue
+		}
+		tried++
+
+		req, err := newQueryRequest(d, method, params)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if conf.InfluxDBUserName != "" && conf.InfluxDBPassword != "" {
+			req.SetBasicAuth(conf.InfluxDBUserName, conf.InfluxDBPassword)
+		}
+
+		span := tracing.StartChild("backend_query", tracing.KindClient, trace)
+		span.SetAttribute("backend", d.URL)
+		if tp := span.SpanContext().Traceparent(); tp != "" {
+			req.Header.Set("traceparent", tp)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Infof("Query to backend %s failed: %v", d.URL, err)
+			span.SetError(err.Error())
+			span.Finish()
+			lastErr = err
+			continue
+		}
+		span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			log.Infof("Query to backend %s failed with status code %d", d.URL, resp.StatusCode)
+			resp.Body.Close()
+			lastErr = fmt.Errorf("backend returned status code %d", resp.StatusCode)
+			span.SetError(lastErr.Error())
+			span.Finish()
+			continue
+		}
+		span.Finish()
+		return resp, nil
+	}
+
+	if tried == 0 {
+		return nil, fmt.Errorf("no healthy backend available")
+	}
+	return nil, lastErr
+}
+
+// newQueryRequest builds the /query request for a backend.
+func newQueryRequest(d *backends.BackendDest, method string, params url.Values) (*http.Request, error) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, "query")
+
+	if method == http.MethodGet {
+		u.RawQuery = params.Encode()
+		return http.NewRequest(http.MethodGet, u.String(), nil)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// token is a lexical token of an InfluxQL query.
+type token struct {
+	val    string // Unquoted value of the token
+	ident  bool   // true for identifiers, keywords and numbers
+	quoted bool   // true for double quoted identifiers
+}
+
+// checkQuery makes sure that every statement of q is a read only statement that
+// does not reference any database other than db.
+func checkQuery(q string, db string) error {
+	toks, err := tokenize(q)
+	if err != nil {
+		return err
+	}
+
+	var stmt []token
+	for _, t := range append(toks, token{val: ";"}) {
+		if !t.ident && t.val == ";" {
+			if len(stmt) > 0 {
+				if err := checkStatement(stmt, db); err != nil {
+					return err
+				}
+			}
+			stmt = nil
+			continue
+		}
+		stmt = append(stmt, t)
+	}
+	return nil
+}
+
+// checkStatement validates a single tokenized statement.
+func checkStatement(stmt []token, db string) error {
+	first := keyword(stmt[0])
+	switch first {
+	case "SELECT":
+	case "SHOW":
+		if len(stmt) < 2 || !showAllowed[keyword(stmt[1])] {
+			return fmt.Errorf("statement not allowed: only SELECT and SHOW MEASUREMENTS/TAG/FIELD/SERIES/RETENTION queries are supported")
+		}
+	default:
+		return fmt.Errorf("statement not allowed: %s", first)
+	}
+
+	for i, t := range stmt {
+		switch keyword(t) {
+		case "INTO":
+			return fmt.Errorf("SELECT INTO is not allowed")
+		case "ON":
+			if i+1 < len(stmt) && placeholder(stmt, i+1) {
+				return fmt.Errorf("bound parameters are not allowed as database")
+			}
+			if i+1 < len(stmt) && stmt[i+1].ident && stmt[i+1].val != db {
+				return fmt.Errorf("access to database %s is not allowed", stmt[i+1].val)
+			}
+		}
+
+		// A fully qualified measurement looks like db.rp.measurement or db..measurement.
+		if t.ident && i+2 < len(stmt) && stmt[i+1].val == "." && !stmt[i+1].ident {
+			if n := stmt[i+2]; (!n.ident && n.val == ".") || (i+3 < len(stmt) && !stmt[i+3].ident && stmt[i+3].val == ".") {
+				if i > 0 && placeholder(stmt, i-1) {
+					return fmt.Errorf("bound parameters are not allowed as database")
+				}
+				if t.val != db {
+					return fmt.Errorf("access to database %s is not allowed", t.val)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// placeholder tells whether a bound parameter, $name, starts at stmt[i].
+func placeholder(stmt []token, i int) bool {
+	return !stmt[i].ident && stmt[i].val == "$" && i+1 < len(stmt) && stmt[i+1].ident
+}
+
+// keyword returns the upper cased value of an unquoted identifier.
+func keyword(t token) string {
+	if !t.ident || t.quoted {
+		return ""
+	}
+	return strings.ToUpper(t.val)
+}
+
+// tokenize splits an InfluxQL query into tokens. String literals, regular expressions
+// and comments are dropped as they can not reference a database.
+func tokenize(q string) ([]token, error) {
+	var toks []token
+	r := []rune(q)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			// InfluxDB drops /* */ comments wherever they are, even where a regular expression may start.
+			end := i + 2
+			for end+1 < len(r) && !(r[end] == '*' && r[end+1] == '/') {
+				end++
+			}
+			if end+1 >= len(r) {
+				return nil, fmt.Errorf("unterminated comment in query")
+			}
+			i = end + 2
+		case c == '\'' || c == '"' || (c == '/' && regexAllowed(toks)):
+			end, val, err := quoted(r, i)
+			if err != nil {
+				return nil, err
+			}
+			if c == '"' {
+				toks = append(toks, token{val: val, ident: true, quoted: true})
+			}
+			i = end
+		case isIdentRune(c):
+			start := i
+			for i < len(r) && isIdentRune(r[i]) {
+				i++
+			}
+			toks = append(toks, token{val: string(r[start:i]), ident: true})
+		default:
+			toks = append(toks, token{val: string(c)})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+// quoted reads a quoted string starting at r[start] and returns the position after the
+// closing quote together with the unescaped content.
+func quoted(r []rune, start int) (int, string, error) {
+	q := r[start]
+	var b bytes.Buffer
+	for i := start + 1; i < len(r); i++ {
+		if r[i] == '\\' && i+1 < len(r) {
+			i++
+			b.WriteRune(r[i])
+			continue
+		}
+		if r[i] == q {
+			return i + 1, b.String(), nil
+		}
+		b.WriteRune(r[i])
+	}
+	return 0, "", fmt.Errorf("unterminated %c in query", q)
+}
+
+// regexAllowed tells whether a '/' at this point starts a regular expression rather than a division.
+func regexAllowed(toks []token) bool {
+	if len(toks) == 0 {
+		return true
+	}
+	prev := toks[len(toks)-1]
+	if !prev.ident {
+		return prev.val != ")"
+	}
+	switch keyword(prev) {
+	case "SELECT", "FROM", "BY":
+		return true
+	}
+	return false
+}
+
+func isIdentRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
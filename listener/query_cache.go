@@ -0,0 +1,182 @@
+// Package listener provides code for managing incoming http requests.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package listener
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// cachedResponse is a query response kept in the query cache.
+type cachedResponse struct {
+	key     string
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// queryCache is an in-memory TTL cache for query responses. The memory used by the cached
+// bodies is bounded by maxBytes, the least recently used entries are evicted first.
+type queryCache struct {
+	sync.Mutex
+	ttl      time.Duration
+	maxBytes int
+	size     int
+	lru      *list.List
+	entries  map[string]*list.Element
+}
+
+// newQueryCache returns a cache, or nil (no caching) if ttl or maxBytes is not positive.
+func newQueryCache(ttl time.Duration, maxBytes int) *queryCache {
+	if ttl <= 0 || maxBytes <= 0 {
+		return nil
+	}
+	return &queryCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// maxEntryBytes is the biggest response body that will be cached.
+func (c *queryCache) maxEntryBytes() int {
+	return c.maxBytes / 16
+}
+
+// get returns the cached response for key if it has not expired.
+func (c *queryCache) get(key string) (*cachedResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	r := e.Value.(*cachedResponse)
+	if time.Now().After(r.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return r, true
+}
+
+// set adds a response to the cache, evicting old entries to stay within maxBytes.
+func (c *queryCache) set(key string, header http.Header, body []byte) {
+	if c == nil || len(body) > c.maxEntryBytes() {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	r := &cachedResponse{key: key, header: header, body: body, expires: time.Now().Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(r)
+	c.size += len(body)
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry. Must be called with the lock held.
+func (c *queryCache) remove(e *list.Element) {
+	r := c.lru.Remove(e).(*cachedResponse)
+	delete(c.entries, r.key)
+	c.size -= len(r.body)
+}
+
+// normalizeQuery collapses whitespace outside of quotes, regular expressions and comments and drops trailing
+// semicolons so that the same query written differently hits the same cache entry. It scans the query like
+// tokenize, a line comment keeps the newline ending it.
+func normalizeQuery(q string) string {
+	var out []rune
+	var toks []token
+	r := []rune(q)
+	space := false
+	emit := func(s []rune) {
+		if space && len(out) > 0 {
+			out = append(out, ' ')
+		}
+		space = false
+		out = append(out, s...)
+	}
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			space = true
+			i++
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			start := i
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+			if i < len(r) {
+				i++
+			}
+			emit(r[start:i])
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			start, end := i, i+2
+			for end+1 < len(r) && !(r[end] == '*' && r[end+1] == '/') {
+				end++
+			}
+			i = end + 2
+			if i > len(r) {
+				i = len(r)
+			}
+			emit(r[start:i])
+		case c == '\'' || c == '"' || (c == '/' && regexAllowed(toks)):
+			end, val, err := quoted(r, i)
+			if err != nil {
+				end = len(r)
+			}
+			if c == '"' {
+				toks = append(toks, token{val: val, ident: true, quoted: true})
+			}
+			emit(r[i:end])
+			i = end
+		case isIdentRune(c):
+			start := i
+			for i < len(r) && isIdentRune(r[i]) {
+				i++
+			}
+			toks = append(toks, token{val: string(r[start:i]), ident: true})
+			emit(r[start:i])
+		default:
+			toks = append(toks, token{val: string(c)})
+			emit(r[i : i+1])
+			i++
+		}
+	}
+	for len(out) > 0 && (out[len(out)-1] == ';' || out[len(out)-1] == ' ') {
+		out = out[:len(out)-1]
+	}
+	return string(out)
+}
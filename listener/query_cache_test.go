@@ -0,0 +1,64 @@
+package listener
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	q := normalizeQuery("SELECT  *\n\tFROM cpu WHERE host = 'a  b';  ")
+	exp := "SELECT * FROM cpu WHERE host = 'a  b'"
+	if q != exp {
+		t.Errorf("Normalized query does not match. Got: %q, Expected: %q", q, exp)
+	}
+
+	// Whitespace in comments and regular expressions matters.
+	different := [][2]string{
+		{"SELECT * FROM cpu -- x\nWHERE a=1", "SELECT * FROM cpu -- x WHERE a=1"},
+		{"SELECT * FROM cpu WHERE host =~ /a  b/", "SELECT * FROM cpu WHERE host =~ /a b/"},
+		{"SELECT * FROM /a  b/", "SELECT * FROM /a b/"},
+		{"SELECT a / 2 FROM /x  y/", "SELECT a / 2 FROM /x y/"},
+		{"SELECT * FROM cpu /* a  b */", "SELECT * FROM cpu /* a b */"},
+	}
+	for _, d := range different {
+		if normalizeQuery(d[0]) == normalizeQuery(d[1]) {
+			t.Errorf("Queries should not be normalized the same: %q, %q", d[0], d[1])
+		}
+	}
+	if q := normalizeQuery("SELECT a  /  2 FROM cpu"); q != "SELECT a / 2 FROM cpu" {
+		t.Errorf("Whitespace around a division should be collapsed. Got: %q", q)
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	c := newQueryCache(time.Minute, 64)
+	c.set("a", http.Header{}, []byte("1234"))
+	if r, ok := c.get("a"); !ok || string(r.body) != "1234" {
+		t.Error("Cached response should be returned")
+	}
+
+	// Entries bigger than maxBytes/16 are not cached.
+	c.set("b", http.Header{}, []byte("12345"))
+	if _, ok := c.get("b"); ok {
+		t.Error("Response bigger than the max entry size should not be cached")
+	}
+
+	// The least recently used entries are evicted once maxBytes is reached.
+	for _, k := range []string{"c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p", "q", "r"} {
+		c.set(k, http.Header{}, []byte("1234"))
+	}
+	if _, ok := c.get("a"); ok {
+		t.Error("Least recently used entry should have been evicted")
+	}
+	if c.size > c.maxBytes {
+		t.Errorf("Cache size %d is over the limit %d", c.size, c.maxBytes)
+	}
+
+	e := newQueryCache(time.Nanosecond, 64)
+	e.set("a", http.Header{}, []byte("1234"))
+	time.Sleep(time.Millisecond)
+	if _, ok := e.get("a"); ok {
+		t.Error("Expired response should not be returned")
+	}
+}
@@ -0,0 +1,130 @@
+package listener
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/usage"
+)
+
+var allowedQueries = []string{
+	`SELECT mean("usage_idle") FROM "cpu" WHERE time > now() - 1h GROUP BY time(1m)`,
+	`SELECT * FROM "autogen"."cpu" LIMIT 10`,
+	`SELECT * FROM "telegraf1"."autogen"."cpu"`,
+	`SELECT * FROM telegraf1..cpu`,
+	`SELECT mean(value) / 1.5 FROM /^cpu.*/ WHERE host =~ /web.db.1/`,
+	`SELECT * FROM cpu WHERE host = 'other.autogen.cpu'`,
+	`SHOW MEASUREMENTS; SHOW TAG VALUES WITH KEY = "host"`,
+	`SHOW RETENTION POLICIES ON "telegraf1"`,
+	`SHOW FIELD KEYS FROM cpu`,
+	`SELECT /* usage */ * FROM telegraf1..cpu WHERE host =~ /web/`,
+}
+
+var rejectedQueries = []string{
+	`DROP DATABASE telegraf1`,
+	`CREATE USER foo WITH PASSWORD 'bar' WITH ALL PRIVILEGES`,
+	`SHOW DATABASES`,
+	`SHOW USERS`,
+	`SELECT * FROM "telegraf2"."autogen"."cpu"`,
+	`SELECT * FROM telegraf2..cpu`,
+	`SELECT * INTO "telegraf1"."autogen"."copy" FROM cpu`,
+	`SHOW MEASUREMENTS ON telegraf2`,
+	`SELECT * FROM cpu; DROP MEASUREMENT cpu`,
+	`SELECT * FROM cpu WHERE host = 'unterminated`,
+	`SELECT /*/ */ * FROM telegraf2..cpu WHERE a =~ /x/`,
+	`SELECT /*/ */ * FROM cpu WHERE a =~ /x/; DROP DATABASE telegraf1`,
+	`SELECT * FROM /* telegraf1..cpu */ telegraf2..cpu`,
+	`SELECT * FROM cpu /* unterminated`,
+	`SHOW MEASUREMENTS ON $d`,
+	`SELECT * FROM $telegraf1.autogen.cpu`,
+	`SELECT * FROM $telegraf1..cpu`,
+}
+
+func TestCheckQuery(t *testing.T) {
+	for _, q := range allowedQueries {
+		if err := checkQuery(q, "telegraf1"); err != nil {
+			t.Errorf("Query should be allowed: %s, Got error: %v", q, err)
+		}
+	}
+	for _, q := range rejectedQueries {
+		if err := checkQuery(q, "telegraf1"); err == nil {
+			t.Errorf("Query should be rejected: %s", q)
+		}
+	}
+}
+
+func TestAPIKeyFromRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/query", nil)
+	req.Header.Set("Service-API-Key", "7ba4e75a")
+	if k := apiKeyFromRequest(req, "Service-API-Key"); k != "7ba4e75a" {
+		t.Errorf("API key from header does not match. Got: %s, Expected: %s", k, "7ba4e75a")
+	}
+
+	req, _ = http.NewRequest("GET", "/query", nil)
+	req.Header.Set("Authorization", "Token 97dafb09")
+	if k := apiKeyFromRequest(req, "Service-API-Key"); k != "97dafb09" {
+		t.Errorf("API key from token does not match. Got: %s, Expected: %s", k, "97dafb09")
+	}
+
+	req, _ = http.NewRequest("GET", "/query", nil)
+	req.SetBasicAuth("grafana", "97dafb09")
+	if k := apiKeyFromRequest(req, "Service-API-Key"); k != "97dafb09" {
+		t.Errorf("API key from basic auth does not match. Got: %s, Expected: %s", k, "97dafb09")
+	}
+}
+
+func TestQueryUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := usage.NewStore(filepath.Join(dir, "usage.jsonl"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := config.APIKeyMap{"k": {Name: "a", InfluxDBName: "telegraf1"}}
+	httpConfig := &HTTPListenerConfig{
+		APIKeyHeaderName: "X-API-Key",
+		Customers:        config.NewRegistry(&config.Configs{}, keys, "", false, ""),
+		UsageStore:       store,
+	}
+	proxy := &queryProxy{client: &http.Client{}}
+	for _, q := range []string{rejectedQueries[0], allowedQueries[0]} {
+		req := httptest.NewRequest(http.MethodGet, "/query?q="+url.QueryEscape(q), nil)
+		req.Header.Set("X-API-Key", "k")
+		query(httptest.NewRecorder(), req, httpConfig, proxy)
+	}
+
+	records := store.Records(time.Time{}, time.Now().Add(time.Hour), "a")
+	if len(records) != 1 || records[0].Queries != 1 {
+		t.Errorf("Only the allowed query should be billed. Got: %v", records)
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	keys := config.APIKeyMap{"k": {Name: "a", InfluxDBName: "telegraf1"}}
+	httpConfig := &HTTPListenerConfig{
+		APIKeyHeaderName: "X-API-Key",
+		Customers:        config.NewRegistry(&config.Configs{}, keys, "", false, ""),
+	}
+	proxy := &queryProxy{client: &http.Client{}}
+
+	// Bound parameters are substituted by the backends after the checks.
+	for _, q := range []string{`SHOW MEASUREMENTS ON $d`, `SELECT * FROM $telegraf1.autogen.cpu`, `SELECT * FROM cpu WHERE host = $host`} {
+		req := httptest.NewRequest(http.MethodGet, "/query?q="+url.QueryEscape(q)+"&params="+url.QueryEscape(`{"d": "telegraf2", "telegraf1": "telegraf2", "host": "a"}`), nil)
+		req.Header.Set("X-API-Key", "k")
+		w := httptest.NewRecorder()
+		query(w, req, httpConfig, proxy)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Query with bound parameters should be rejected: %s, Got: %d", q, w.Code)
+		}
+	}
+}
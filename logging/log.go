@@ -9,8 +9,12 @@ package logging
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -24,33 +28,79 @@ func init() {
 	logrus.SetOutput(os.Stdout)
 }
 
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configure a log stream.
+type Options struct {
+	Output     string // stdout, stderr or a file
+	Level      string
+	Format     string // text or json
+	MaxSize    int64  // size in bytes a log file is rotated at, 0 disables the rotation
+	MaxBackups int    // number of rotated log files kept
+}
+
+// Access is the logger of the http access log. It is configured separately from the main log so that
+// the access log can go to its own stream.
+var Access = &logrus.Logger{
+	Out:       os.Stdout,
+	Formatter: new(MyFormatter),
+	Hooks:     make(logrus.LevelHooks),
+	Level:     logrus.InfoLevel,
+}
+
 // Configure logging
-func Configure(output string, l string) {
+func Configure(o Options) {
+	configure(logrus.StandardLogger(), o)
+}
 
+// ConfigureAccess configures the access log. An output of "off" disables it.
+func ConfigureAccess(o Options) {
+	if o.Output == "off" {
+		Access.Out = ioutil.Discard
+		return
+	}
+	configure(Access, o)
+}
+
+func configure(l *logrus.Logger, o Options) {
+	output := o.Output
 	if output == "" || output == "stdout" {
-		logrus.SetOutput(os.Stdout)
+		l.Out = os.Stdout
 	} else if output == "stderr" {
-		logrus.SetOutput(os.Stderr)
+		l.Out = os.Stderr
 	} else {
-		f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0755)
+		f, err := OpenRotatingFile(output, o.MaxSize, o.MaxBackups)
 		if err != nil {
 			logrus.Fatal(err)
 		}
-		logrus.SetOutput(f)
+		l.Out = f
+	}
+
+	switch o.Format {
+	case "", FormatText:
+		l.Formatter = new(MyFormatter)
+	case FormatJSON:
+		l.Formatter = &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+	default:
+		logrus.Fatal("Unknown log format ", o.Format)
 	}
 
-	if l == "" {
+	if o.Level == "" {
 		return
 	}
 
-	if level, err := logrus.ParseLevel(l); err != nil {
-		logrus.Fatal("Unknown loglevel ", l)
+	if level, err := logrus.ParseLevel(o.Level); err != nil {
+		logrus.Fatal("Unknown loglevel ", o.Level)
 	} else {
-		logrus.SetLevel(level)
+		l.SetLevel(level)
 	}
 }
 
-// MyFormatter is our custom formatter
+// MyFormatter is our custom formatter. The fields of an entry are appended to the message as key=value.
 type MyFormatter struct{}
 
 // Format an entry
@@ -60,7 +110,22 @@ func (f *MyFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	if !ok {
 		name = "default"
 	}
-	fmt.Fprintf(b, "%s [%-5.5s] (%s): %s\n", entry.Time.Format("2006-01-02 15:04:05"), strings.ToUpper(entry.Level.String()), name, entry.Message)
+	fmt.Fprintf(b, "%s [%-5.5s] (%s): %s", entry.Time.Format("2006-01-02 15:04:05"), strings.ToUpper(entry.Level.String()), name, entry.Message)
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		if k != "name" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(entry.Data[k])
+		if strings.ContainsAny(v, " \"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(b, " %s=%s", k, v)
+	}
+	b.WriteByte('\n')
 	return b.Bytes(), nil
 }
 
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestMyFormatterFields(t *testing.T) {
+	e := &logrus.Entry{
+		Time:    time.Date(2017, 6, 1, 10, 2, 0, 0, time.UTC),
+		Level:   logrus.InfoLevel,
+		Message: "request",
+		Data:    logrus.Fields{"name": "listener", "status": 204, "user_agent": "telegraf 1.4", "customer": "servicex"},
+	}
+	b, err := new(MyFormatter).Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `2017-06-01 10:02:00 [INFO ] (listener): request customer=servicex status=204 user_agent="telegraf 1.4"` + "\n"
+	if string(b) != expected {
+		t.Errorf("Got: %q, Expected: %q", b, expected)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "router.log")
+
+	r, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(l)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+
+	for f, expected := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("%s Got: %q, Expected: %q", filepath.Base(f), b, expected)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 rotated files")
+	}
+}
@@ -22,10 +22,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -34,9 +37,14 @@ import (
 	"github.com/samitpal/influxdb-router/api"
 	"github.com/samitpal/influxdb-router/backends"
 	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/lifecycle"
 	"github.com/samitpal/influxdb-router/listener"
 	"github.com/samitpal/influxdb-router/logging"
+	"github.com/samitpal/influxdb-router/ratelimit"
 	"github.com/samitpal/influxdb-router/stats"
+	"github.com/samitpal/influxdb-router/tail"
+	"github.com/samitpal/influxdb-router/tracing"
+	"github.com/samitpal/influxdb-router/usage"
 	"github.com/samitpal/influxdb-router/writer"
 )
 
@@ -50,20 +58,63 @@ var (
 		httpPort           string
 		httpsPort          string
 		incomingQueuecap   int
+		memoryBudget       int64
 		secure             bool
 		sslServerCert      string
 		sslCAServerCert    string
 		sslServerKey       string
 		sslClientCertAuth  bool
 		configFile         string
+		customersStateFile string
 		apiKeyHeaderName   string
 		waitBeforeShutdown int
+		shutdownTimeout    int
+		shutdownSpillDir   string
 		statsdServer       string
 		statsInterval      int
+		statsdFormat       string
+		statsdNetwork      string
+		statsdMTU          int
+		topMeasurements    int
+		usageFile          string
+		usageRetention     int
+		tracingExporter    string
+		journalSize        int
+		journalFile        string
+		tracingEndpoint    string
+		tracingFile        string
+		tracingSampleRatio float64
+		metricsSinks       string
+		metricsInfluxURL   string
+		metricsInfluxDB    string
+		metricsInfluxUser  string
+		metricsInfluxPass  string
+		queryTimeout       int
+		queryCacheTTL      int
+		queryCacheMaxBytes int
+		maxBatchBytes      int64
+		logLevel           string
+		logFormat          string
+		logOutput          string
+		logMaxSize         int
+		logMaxBackups      int
+		accessLogOutput    string
+		apiSecure          bool
+		apiSSLCAServerCert string
+		apiSSLServerCert   string
+		apiSSLServerKey    string
+		apiSSLClientAuth   bool
+		apiAuthFile        string
+		tailMaxDuration    int
+		tailMaxRate        int
+		tailMaxSessions    int
+		readyMaxQueueRatio float64
+		readyBackends      bool
+		apiDebug           bool
 		version            bool
 	}
 
-	sigChan = make(chan os.Signal)
+	sigChan = make(chan os.Signal, 1)
 	log     = logging.For("main")
 	version string
 	date    string
@@ -78,36 +129,131 @@ func init() {
 	flag.StringVar(&options.apiAddr, "api-listen-addr", "127.0.0.1", "InfluxDB router api listen address")
 	flag.StringVar(&options.apiPort, "api-listen-http-port", "8080", "InfluxDB router api listen port")
 	flag.StringVar(&options.httpsPort, "listen-https-port", "8443", "InfluxDB router listen port (https)")
-	flag.IntVar(&options.incomingQueuecap, "incoming-queue-cap", 500000, "In-flight incoming message queue capacity")
+	flag.IntVar(&options.incomingQueuecap, "incoming-queue-cap", 500000, "In-flight incoming message capacity across the incoming queues of all the customers")
+	flag.Int64Var(&options.memoryBudget, "memory-budget-bytes", 0, "Max bytes held by all the incoming, outgoing and retry queues together. 0 means no limit.")
 	flag.BoolVar(&options.secure, "secure", false, "Whether to turn on ssl.")
 	flag.StringVar(&options.sslCAServerCert, "ssl-ca-server-cert", "", "CA Server TLS Certificate. Useful when client cert based auth is enabled.")
 	flag.StringVar(&options.sslServerCert, "ssl-server-cert", "./server.crt", "Server TLS Certificate")
 	flag.StringVar(&options.sslServerKey, "ssl-server-key", "./server.key", "Server TLS Key")
 	flag.BoolVar(&options.sslClientCertAuth, "ssl-client-cert-auth", false, "Whether to turn on ssl client certificate based auth")
 	flag.StringVar(&options.configFile, "config_file", "./config.toml", "Configuration options.")
+	flag.StringVar(&options.customersStateFile, "customers-state-file", "", "File the customers created, updated and deleted with the api are saved to, e.g. ./customers-state.toml. They override the ones of -config_file. Empty keeps the changes in memory only.")
 	flag.StringVar(&options.apiKeyHeaderName, "api-key-header-name", "Service-API-Key", "Name of the API key header.")
 	flag.IntVar(&options.waitBeforeShutdown, "wait-before-shutdown", 1, "Number of seconds to wait before the process shuts down. Health checks will be failed during this time.")
-	flag.StringVar(&options.statsdServer, "statsd-server", "localhost:8125", "statsd server:port for sending metrics")
-	flag.IntVar(&options.statsInterval, "stats-interval", 30, "Interval in seconds for sending statsd metrics.")
+	flag.IntVar(&options.shutdownTimeout, "shutdown-timeout", 30, "Number of seconds to finish the requests and write the queued batches to the healthy backends on shutdown.")
+	flag.StringVar(&options.shutdownSpillDir, "shutdown-spill-dir", "", "Directory the batches still queued after -shutdown-timeout are written to. Empty drops them. They are not replayed on startup.")
+	flag.StringVar(&options.statsdServer, "statsd-server", "localhost:8125", "statsd server:port (or socket path) for sending metrics")
+	flag.StringVar(&options.statsdNetwork, "statsd-network", "udp", "Network of the statsd server. Can be 'udp', 'tcp', 'unix' or 'unixgram'.")
+	flag.IntVar(&options.statsdMTU, "statsd-mtu", 1432, "Max size in bytes of the packets sent to statsd. Multiple metrics are packed in a packet.")
+	flag.IntVar(&options.statsInterval, "stats-interval", 30, "Interval in seconds for flushing the metrics to the sinks.")
+	flag.StringVar(&options.statsdFormat, "statsd-format", "plain", "Format of the statsd metrics. Can be 'plain' (customer and backend in the metric name), 'dogstatsd' or 'influx' (tags).")
+	flag.StringVar(&options.metricsSinks, "metrics-sinks", "statsd,prometheus", "Comma separated list of the metrics sinks. Can be any of 'statsd', 'prometheus' and 'influxdb'.")
+	flag.StringVar(&options.metricsInfluxURL, "metrics-influxdb-url", "http://localhost:8086", "InfluxDB backend the influxdb metrics sink writes the router's own metrics to.")
+	flag.StringVar(&options.metricsInfluxDB, "metrics-influxdb-db", "influx_router", "InfluxDB database the influxdb metrics sink writes to.")
+	flag.StringVar(&options.metricsInfluxUser, "metrics-influxdb-username", "", "Username of the influxdb metrics sink.")
+	flag.StringVar(&options.metricsInfluxPass, "metrics-influxdb-password", "", "Password of the influxdb metrics sink.")
+	flag.IntVar(&options.topMeasurements, "top-measurements", 10, "Number of measurements with the most points per customer sent as metrics.")
+	flag.StringVar(&options.usageFile, "usage-file", "", "File the hourly usage records of the customers are saved to, e.g. ./usage.jsonl. Empty disables the usage records.")
+	flag.IntVar(&options.usageRetention, "usage-retention-days", 400, "Number of days the hourly usage records are kept. 0 keeps them forever.")
+	flag.StringVar(&options.tracingExporter, "tracing-exporter", "", "Where to export the traces of the batches. Can be 'otlp', 'stdout' or 'file'. Empty disables tracing.")
+	flag.StringVar(&options.tracingEndpoint, "tracing-otlp-endpoint", "http://localhost:4318", "OpenTelemetry collector the traces are exported to with OTLP over http.")
+	flag.StringVar(&options.tracingFile, "tracing-file", "./traces.json", "File the traces are written to with the 'file' tracing exporter.")
+	flag.Float64Var(&options.tracingSampleRatio, "tracing-sample-ratio", 1, "Ratio of the requests without a sampled traceparent header that are traced.")
+	flag.IntVar(&options.journalSize, "message-journal-size", 100000, "Number of batch lifecycle events kept in memory for the messages api. 0 disables the journal.")
+	flag.StringVar(&options.journalFile, "message-journal-file", "", "File the batch lifecycle events are saved to, so that they survive restarts. Empty keeps them in memory only.")
+	flag.IntVar(&options.queryTimeout, "query-timeout", 30, "Timeout in seconds for queries proxied to the InfluxDB backends.")
+	flag.IntVar(&options.queryCacheTTL, "query-cache-ttl", 10, "Time in seconds query responses are cached. 0 disables the query cache.")
+	flag.IntVar(&options.queryCacheMaxBytes, "query-cache-max-bytes", 64*1024*1024, "Max memory in bytes used by the query cache.")
+	flag.Int64Var(&options.maxBatchBytes, "max-batch-bytes", usage.DefaultMaxBatchBytes, "Max uncompressed size in bytes of a batch, larger batches are rejected with a 413.")
+	flag.StringVar(&options.logLevel, "log-level", "info", "Log level. Can be 'debug', 'info', 'warn' or 'error'.")
+	flag.StringVar(&options.logFormat, "log-format", "text", "Format of the log and the access log. Can be 'text' or 'json'.")
+	flag.StringVar(&options.logOutput, "log-output", "stdout", "Where to write the log. Can be 'stdout', 'stderr' or a file.")
+	flag.IntVar(&options.logMaxSize, "log-max-size-mb", 100, "Size in MB a log file is rotated at. 0 disables the rotation.")
+	flag.IntVar(&options.logMaxBackups, "log-max-backups", 5, "Number of rotated log files kept.")
+	flag.StringVar(&options.accessLogOutput, "access-log-output", "stdout", "Where to write the http access log. Can be 'stdout', 'stderr', a file or 'off'.")
+	flag.BoolVar(&options.apiSecure, "api-secure", false, "Whether to turn on ssl for the api.")
+	flag.StringVar(&options.apiSSLCAServerCert, "api-ssl-ca-server-cert", "", "CA certificate the api verifies the client certificates with.")
+	flag.StringVar(&options.apiSSLServerCert, "api-ssl-server-cert", "./server.crt", "Server TLS Certificate of the api")
+	flag.StringVar(&options.apiSSLServerKey, "api-ssl-server-key", "./server.key", "Server TLS Key of the api")
+	flag.BoolVar(&options.apiSSLClientAuth, "api-ssl-client-cert-auth", false, "Whether the api requires a client certificate.")
+	flag.StringVar(&options.apiAuthFile, "api-auth-file", "", "Toml file with the tokens and client certificates allowed to use the api and their roles. Empty disables the api authentication.")
+	flag.BoolVar(&options.apiDebug, "api-debug", false, "Whether to serve pprof, expvar and the goroutines and queues snapshots on the api.")
+	flag.IntVar(&options.tailMaxDuration, "tail-max-duration", 300, "Max duration in seconds of a tail of the batches of a customer.")
+	flag.IntVar(&options.tailMaxRate, "tail-max-rate", 1000, "Max lines per second streamed by a tail.")
+	flag.IntVar(&options.tailMaxSessions, "tail-max-sessions", 5, "Max number of tails at a time.")
+	flag.Float64Var(&options.readyMaxQueueRatio, "ready-max-queue-ratio", 0.9, "The router is not ready when the incoming queues are fuller than this ratio of -incoming-queue-cap. 0 disables the check.")
+	flag.BoolVar(&options.readyBackends, "ready-healthy-backends", true, "Whether the router is not ready when a customer has no healthy backend.")
 	flag.BoolVar(&options.version, "version", false, "version of the binary.")
 
 	envy.Parse("INFLUX")
 	flag.Parse()
 }
 
+// shutdown is what is stopped on shutdown.
+type shutdown struct {
+	healthCheck   chan struct{}
+	listener      *http.Server
+	api           *http.Server
+	customers     *config.Registry
+	ingress       *backends.Ingress
+	stopWriter    chan struct{}
+	writerStopped chan struct{}
+	usageStore    *usage.Store
+}
+
 // Handles signal events.
-func handleSignals(h chan bool) {
+func handleSignals(s shutdown) {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 	// Fail lb health checks.
-	h <- true
+	close(s.healthCheck)
 	log.Infof("Waiting for %d secs before shutdown", options.waitBeforeShutdown)
 	timeOut := time.NewTimer(time.Second * time.Duration(options.waitBeforeShutdown))
 	<-timeOut.C
 	log.Info("Shutting down")
+	s.run(time.Now().Add(time.Duration(options.shutdownTimeout) * time.Second))
 	os.Exit(0)
 }
 
+// run stops accepting batches, waits for the requests in flight and the queues to be written to the healthy
+// backends till the deadline, spills what is left to disk and flushes the journal, the usage and the traces.
+func (s shutdown) run(deadline time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := s.listener.Shutdown(ctx); err != nil {
+		log.Errorf("Error waiting for the requests in flight: %v", err)
+	}
+
+	if writer.Drain(s.customers, s.ingress, deadline) {
+		log.Info("All the queued batches are written")
+	}
+	close(s.stopWriter)
+	<-s.writerStopped
+	n, err := writer.Spill(options.shutdownSpillDir, s.customers, s.ingress)
+	if err != nil {
+		log.Errorf("Error spilling the queued batches to %s: %v", options.shutdownSpillDir, err)
+	}
+	if n > 0 {
+		log.Infof("Spilled %d batches to %s", n, options.shutdownSpillDir)
+	}
+
+	if lifecycle.Default != nil {
+		if err := lifecycle.Default.Flush(); err != nil {
+			log.Errorf("Error writing the message journal: %v", err)
+		}
+	}
+	if s.usageStore != nil {
+		if err := s.usageStore.Save(); err != nil {
+			log.Errorf("Error saving usage records: %v", err)
+		}
+	}
+	tracing.Default.Flush(5 * time.Second)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.api.Shutdown(ctx)
+}
+
 func displayVersion() {
 	fmt.Println("Version: ", version)
 	fmt.Println("Build Time: ", date)
@@ -115,12 +261,42 @@ func displayVersion() {
 	os.Exit(0)
 }
 
+// restoreQuotas carries the usage of the day saved in the usage records over to the daily quotas of the
+// customers, which would otherwise start over on a restart.
+func restoreQuotas(customers *config.Registry, usageStore *usage.Store) {
+	now := time.Now().UTC()
+	day := now.Truncate(24 * time.Hour)
+	for _, c := range customers.Keys() {
+		u := ratelimit.WriteUsage{Day: day.Format("2006-01-02")}
+		for _, r := range usageStore.Records(day, now.Add(time.Hour), c.Name) {
+			u.Batches += r.Batches
+			u.Bytes += r.Bytes
+			u.Points += r.Points
+		}
+		c.WriteLimiter.SetUsage(u)
+	}
+}
+
 func main() {
 
 	if options.version {
 		displayVersion()
 	}
 
+	logging.Configure(logging.Options{
+		Output:     options.logOutput,
+		Level:      options.logLevel,
+		Format:     options.logFormat,
+		MaxSize:    int64(options.logMaxSize) * 1024 * 1024,
+		MaxBackups: options.logMaxBackups,
+	})
+	logging.ConfigureAccess(logging.Options{
+		Output:     options.accessLogOutput,
+		Format:     options.logFormat,
+		MaxSize:    int64(options.logMaxSize) * 1024 * 1024,
+		MaxBackups: options.logMaxBackups,
+	})
+
 	log.Info(`
     ____     _____           ___  ___     ___            __
    /  _/__  / _/ /_ ____ __ / _ \/ _ )   / _ \___  __ __/ /____ ____
@@ -131,65 +307,172 @@ func main() {
 	ready := make(chan bool, 1)
 
 	// Used to fail lb healthchecks.
-	healthCheck := make(chan bool, 1)
+	healthCheck := make(chan struct{})
 
-	incomingQueue := make(chan *backends.Payload, options.incomingQueuecap)
+	ingress := backends.NewIngress(options.incomingQueuecap)
+	backends.GlobalBudget.SetLimit(options.memoryBudget)
 
 	conf, err := config.NewConfigs(options.configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Print(conf.LogConfig())
-
-	// Build the ApiKeyMap config now.
-	apiConf, err := config.NewAPIKeyMap(conf.Customers, options.authEnabled, options.authMode)
+	// Build the ApiKeyMap config now. The customers changed with the api are saved to the state file.
+	customers, err := config.LoadRegistry(conf, options.customersStateFile, options.authEnabled, options.authMode)
 	if err != nil {
 		log.Fatal(err)
 	}
+	current, _ := customers.Snapshot()
+	log.Print(current.LogConfig())
 
-	// Output writer.
-	go writer.OutQueueWriter(apiConf, incomingQueue, ready)
+	// Tracing.
+	switch options.tracingExporter {
+	case "otlp":
+		tracing.Default = tracing.NewTracer(tracing.NewOTLP(options.tracingEndpoint), options.tracingSampleRatio)
+	case "stdout":
+		tracing.Default = tracing.NewTracer(&tracing.Writer{W: os.Stdout}, options.tracingSampleRatio)
+	case "file":
+		f, err := os.OpenFile(options.tracingFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tracing.Default = tracing.NewTracer(&tracing.Writer{W: f}, options.tracingSampleRatio)
+	case "":
+	default:
+		log.Fatalf("Unknown tracing exporter: %s", options.tracingExporter)
+	}
 
-	// start statsd metrics tracker
-	c, err := stats.ConnectStatsd(options.statsdServer, "udp")
-	if err != nil {
-		log.Errorf("Error connecting to statsd server: %v", err)
+	// Lifecycle events of the batches.
+	if options.journalSize > 0 {
+		lifecycle.Default, err = lifecycle.NewJournal(options.journalSize, options.journalFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go lifecycle.Default.Run(time.Second)
+	}
+
+	// Hourly usage records.
+	var usageStore *usage.Store
+	if options.usageFile != "" {
+		usageStore, err = usage.NewStore(options.usageFile, time.Duration(options.usageRetention)*24*time.Hour)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go usageStore.Run(time.Minute)
+		restoreQuotas(customers, usageStore)
 	}
 
-	sc := stats.Statsd{
-		Interval: options.statsInterval,
-		Conn:     c,
+	// Output writer.
+	stopWriter, writerStopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		writer.OutQueueWriter(customers, ingress, ready, stopWriter)
+		close(writerStopped)
+	}()
+
+	// start the metrics sinks
+	var prom *stats.Prometheus
+	for _, s := range strings.Split(options.metricsSinks, ",") {
+		switch strings.TrimSpace(s) {
+		case "statsd":
+			stats.Default.AddSink(stats.NewStatsd(options.statsdServer, options.statsdNetwork, options.statsdFormat, options.statsdMTU))
+		case "prometheus":
+			prom = stats.NewPrometheus()
+			stats.Default.AddSink(prom)
+		case "influxdb":
+			// The router's own metrics go through their own out going queue like the ones of a customer.
+			self := config.APIKeyConfig{
+				Dests:            make(map[string]*backends.BackendDest),
+				Name:             "_internal",
+				InfluxDBName:     options.metricsInfluxDB,
+				InfluxDBUserName: options.metricsInfluxUser,
+				InfluxDBPassword: options.metricsInfluxPass,
+				OutgoingQueueCap: 100,
+				RetryQueueCap:    100,
+			}
+			d := backends.NewBackendDest(options.metricsInfluxURL, self.OutgoingQueueCap, self.RetryQueueCap)
+			self.Dests[config.BackendKey(d.URL)] = d
+			writer.StartDest(d, self)
+			stats.Default.AddSink(stats.NewInfluxDB(d))
+			stats.Default.AddGauges(func() []stats.Metric { return stats.DestGauges(self) })
+		case "":
+		default:
+			log.Fatalf("Unknown metrics sink: %s", s)
+		}
 	}
-	go stats.ExportMetrics(&sc, options.incomingQueuecap, incomingQueue, apiConf)
+	stats.Default.AddGauges(func() []stats.Metric { return stats.QueueGauges(ingress, customers.Keys()) })
+	stats.Default.AddGauges(func() []stats.Metric { return stats.UsageGauges(customers.Keys(), options.topMeasurements) })
+	stats.Default.AddGauges(stats.RuntimeGauges)
+	go stats.Default.Run(time.Duration(options.statsInterval) * time.Second)
 
-	// wait till the writer is ready.
-	<-ready
+	// The listener is not ready till the writer is.
+	writerReady := make(chan struct{})
+	go func() {
+		<-ready
+		close(writerReady)
+	}()
 
 	// HTTP Listener.
-	go listener.HTTPListener(&listener.HTTPListenerConfig{
-		Addr:              options.addr,
-		HTTPPort:          options.httpPort,
-		HTTPSPort:         options.httpsPort,
-		IncomingQueue:     incomingQueue,
-		Secure:            options.secure,
-		SSLCAServerCert:   options.sslCAServerCert,
-		SSLServerCert:     options.sslServerCert,
-		SSLServerKey:      options.sslServerKey,
-		SSLClientCertAuth: options.sslClientCertAuth,
-		APIConfig:         apiConf,
-		APIKeyHeaderName:  options.apiKeyHeaderName,
-		HealthCheck:       healthCheck,
-		Statsd:            &sc,
+	listenerSrv := listener.HTTPListener(&listener.HTTPListenerConfig{
+		Addr:               options.addr,
+		HTTPPort:           options.httpPort,
+		HTTPSPort:          options.httpsPort,
+		Ingress:            ingress,
+		Secure:             options.secure,
+		SSLCAServerCert:    options.sslCAServerCert,
+		SSLServerCert:      options.sslServerCert,
+		SSLServerKey:       options.sslServerKey,
+		SSLClientCertAuth:  options.sslClientCertAuth,
+		Customers:          customers,
+		APIKeyHeaderName:   options.apiKeyHeaderName,
+		HealthCheck:        healthCheck,
+		WriterReady:        writerReady,
+		Ready:              listener.ReadyOptions{MaxQueueRatio: options.readyMaxQueueRatio, HealthyBackends: options.readyBackends},
+		QueryTimeout:       options.queryTimeout,
+		QueryCacheTTL:      options.queryCacheTTL,
+		QueryCacheMaxBytes: options.queryCacheMaxBytes,
+		UsageStore:         usageStore,
+		MaxBatchBytes:      options.maxBatchBytes,
 	})
 
 	// API listener.
-	go api.HTTPListener(&api.HTTPListenerConfig{
-		Addr:     options.apiAddr,
-		Port:     options.apiPort,
-		TomlConf: *conf,
-		APIConf:  apiConf,
+	tail.Default.MaxTails = options.tailMaxSessions
+	var apiAuth *api.Auth
+	if options.apiAuthFile != "" {
+		apiAuth, err = api.LoadAuth(options.apiAuthFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	apiSrv := api.HTTPListener(&api.HTTPListenerConfig{
+		Addr:       options.apiAddr,
+		Port:       options.apiPort,
+		Customers:  customers,
+		Ingress:    ingress,
+		Prometheus: prom,
+		UsageStore: usageStore,
+		Journal:    lifecycle.Default,
+
+		Secure:            options.apiSecure,
+		SSLCAServerCert:   options.apiSSLCAServerCert,
+		SSLServerCert:     options.apiSSLServerCert,
+		SSLServerKey:      options.apiSSLServerKey,
+		SSLClientCertAuth: options.apiSSLClientAuth,
+		Auth:              apiAuth,
+
+		TailMaxDuration: time.Duration(options.tailMaxDuration) * time.Second,
+		TailMaxRate:     float64(options.tailMaxRate),
+
+		Debug: options.apiDebug,
 	})
 
-	handleSignals(healthCheck)
+	handleSignals(shutdown{
+		healthCheck:   healthCheck,
+		listener:      listenerSrv,
+		api:           apiSrv,
+		customers:     customers,
+		ingress:       ingress,
+		stopWriter:    stopWriter,
+		writerStopped: writerStopped,
+		usageStore:    usageStore,
+	})
 }
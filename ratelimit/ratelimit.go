@@ -0,0 +1,149 @@
+// Package ratelimit provides token buckets and concurrency limits.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a token bucket rate limiter. A nil *TokenBucket never limits.
+type TokenBucket struct {
+	sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // max tokens in the bucket
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket refilled with rate tokens per second holding at most burst tokens.
+// If burst is less than rate, rate is used as the burst. A rate <= 0 returns nil, i.e. no limit.
+func NewTokenBucket(rate float64, burst float64) *TokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < rate {
+		burst = rate
+	}
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// refill adds the tokens accumulated since the last call. Must be called with the lock held.
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Take tries to remove n tokens from the bucket. If there are not enough tokens it returns false
+// and the time after which the request would succeed.
+func (b *TokenBucket) Take(n float64) (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	if n <= b.tokens {
+		b.tokens -= n
+		return true, 0
+	}
+	// Requests larger than the burst can never succeed in one go, let them drain the bucket instead.
+	// They wait for a full bucket, not for n tokens.
+	if n > b.burst {
+		if b.tokens == b.burst {
+			b.tokens = 0
+			return true, 0
+		}
+		n = b.burst
+	}
+	wait := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// Give puts back n tokens taken from the bucket.
+func (b *TokenBucket) Give(n float64) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Rate returns the rate and burst of the bucket.
+func (b *TokenBucket) Rate() (float64, float64) {
+	if b == nil {
+		return 0, 0
+	}
+	b.Lock()
+	defer b.Unlock()
+	return b.rate, b.burst
+}
+
+// Concurrency limits the number of requests in flight. A nil *Concurrency never limits.
+type Concurrency struct {
+	slots chan struct{}
+}
+
+// NewConcurrency returns a limit of n requests in flight. n <= 0 returns nil, i.e. no limit.
+func NewConcurrency(n int) *Concurrency {
+	if n <= 0 {
+		return nil
+	}
+	return &Concurrency{slots: make(chan struct{}, n)}
+}
+
+// TryAcquire takes a slot without blocking. It returns false if all the slots are in use.
+func (c *Concurrency) TryAcquire() bool {
+	if c == nil {
+		return true
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release gives back a slot taken with TryAcquire.
+func (c *Concurrency) Release() {
+	if c == nil {
+		return
+	}
+	<-c.slots
+}
+
+// InFlight returns the number of slots in use.
+func (c *Concurrency) InFlight() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.slots)
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(10, 20)
+	for i := 0; i < 20; i++ {
+		if ok, _ := b.Take(1); !ok {
+			t.Fatalf("Take %d should succeed within the burst", i)
+		}
+	}
+	ok, wait := b.Take(1)
+	if ok {
+		t.Error("Take should fail once the burst is used up")
+	}
+	if wait <= 0 {
+		t.Errorf("Wait should be positive. Got: %v", wait)
+	}
+
+	// A request larger than the burst waits for a full bucket.
+	if ok, wait := b.Take(1000); ok || wait > 2*time.Second+100*time.Millisecond {
+		t.Errorf("Take over the burst should wait for a full bucket. Got: %v %v, Expected: false, ~2s", ok, wait)
+	}
+}
+
+func TestNilLimits(t *testing.T) {
+	var b *TokenBucket
+	if ok, _ := b.Take(1000); !ok {
+		t.Error("A nil token bucket should never limit")
+	}
+	if NewTokenBucket(0, 10) != nil {
+		t.Error("A zero rate should return a nil token bucket")
+	}
+	var c *Concurrency
+	if !c.TryAcquire() {
+		t.Error("A nil concurrency limit should never limit")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	c := NewConcurrency(2)
+	if !c.TryAcquire() || !c.TryAcquire() {
+		t.Fatal("Two slots should be available")
+	}
+	if c.TryAcquire() {
+		t.Error("Third acquire should fail")
+	}
+	c.Release()
+	if !c.TryAcquire() {
+		t.Error("Acquire should succeed after a release")
+	}
+	if c.InFlight() != 2 {
+		t.Errorf("InFlight does not match. Got: %d, Expected: 2", c.InFlight())
+	}
+}
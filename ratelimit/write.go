@@ -0,0 +1,157 @@
+// Package ratelimit provides token buckets and concurrency limits.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteLimits are the write rate limits and daily quotas of a customer. Zero means no limit.
+type WriteLimits struct {
+	BatchRate   int   `json:"batch_rate_limit"`   // batches per second
+	BatchBurst  int   `json:"batch_burst"`        // max burst of batches above the rate
+	ByteRate    int   `json:"bytes_rate_limit"`   // bytes per second
+	ByteBurst   int   `json:"bytes_burst"`        // max burst of bytes above the rate
+	DailyBytes  int64 `json:"daily_bytes_quota"`  // bytes per UTC day
+	DailyPoints int64 `json:"daily_points_quota"` // points per UTC day
+}
+
+// WriteUsage is the usage of a customer for the current UTC day.
+type WriteUsage struct {
+	Day       string `json:"day"`
+	Bytes     int64  `json:"bytes"`
+	Points    int64  `json:"points"`
+	Batches   int64  `json:"batches"`
+	Throttled int64  `json:"throttled"`
+}
+
+// WriteLimiter enforces WriteLimits and keeps track of the daily usage.
+// Its limits can be changed at runtime with SetLimits.
+type WriteLimiter struct {
+	sync.Mutex
+	limits  WriteLimits
+	batches *TokenBucket
+	bytes   *TokenBucket
+	usage   WriteUsage
+}
+
+// NewWriteLimiter returns a WriteLimiter enforcing l.
+func NewWriteLimiter(l WriteLimits) *WriteLimiter {
+	w := &WriteLimiter{}
+	w.SetLimits(l)
+	return w
+}
+
+// SetLimits replaces the limits. The usage of the day is kept, and so are the tokens of a rate limit that
+// does not change.
+func (w *WriteLimiter) SetLimits(l WriteLimits) {
+	w.Lock()
+	defer w.Unlock()
+	if w.batches == nil || l.BatchRate != w.limits.BatchRate || l.BatchBurst != w.limits.BatchBurst {
+		w.batches = NewTokenBucket(float64(l.BatchRate), float64(l.BatchBurst))
+	}
+	if w.bytes == nil || l.ByteRate != w.limits.ByteRate || l.ByteBurst != w.limits.ByteBurst {
+		w.bytes = NewTokenBucket(float64(l.ByteRate), float64(l.ByteBurst))
+	}
+	w.limits = l
+}
+
+// Limits returns the current limits.
+func (w *WriteLimiter) Limits() WriteLimits {
+	w.Lock()
+	defer w.Unlock()
+	return w.limits
+}
+
+// Usage returns the usage of the current UTC day.
+func (w *WriteLimiter) Usage() WriteUsage {
+	w.Lock()
+	defer w.Unlock()
+	w.rollover(time.Now().UTC())
+	return w.usage
+}
+
+// SetUsage restores the usage of the current UTC day, e.g. after a restart. The usage of another day is ignored.
+func (w *WriteLimiter) SetUsage(u WriteUsage) {
+	w.Lock()
+	defer w.Unlock()
+	w.rollover(time.Now().UTC())
+	if u.Day == w.usage.Day {
+		w.usage = u
+	}
+}
+
+// rollover resets the usage when the UTC day changes. Must be called with the lock held.
+func (w *WriteLimiter) rollover(now time.Time) {
+	if day := now.Format("2006-01-02"); day != w.usage.Day {
+		w.usage = WriteUsage{Day: day}
+	}
+}
+
+// Allow checks a batch of size bytes holding points points against the limits and accounts
+// for it when it is allowed. Otherwise it returns false, the time after which the client
+// should retry and the reason.
+func (w *WriteLimiter) Allow(size int, points int) (bool, time.Duration, string) {
+	w.Lock()
+	defer w.Unlock()
+	now := time.Now().UTC()
+	w.rollover(now)
+
+	if (w.limits.DailyBytes > 0 && w.usage.Bytes+int64(size) > w.limits.DailyBytes) ||
+		(w.limits.DailyPoints > 0 && w.usage.Points+int64(points) > w.limits.DailyPoints) {
+		w.usage.Throttled++
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return false, midnight.Sub(now), "daily quota exceeded"
+	}
+	if ok, wait := w.batches.Take(1); !ok {
+		w.usage.Throttled++
+		return false, wait, "batch rate limit exceeded"
+	}
+	if ok, wait := w.bytes.Take(float64(size)); !ok {
+		// The batch is not written, it does not count against the batch rate.
+		w.batches.Give(1)
+		w.usage.Throttled++
+		return false, wait, "bytes rate limit exceeded"
+	}
+
+	w.usage.Batches++
+	w.usage.Bytes += int64(size)
+	w.usage.Points += int64(points)
+	return true, 0, ""
+}
+
+// Refund gives back what Allow accounted for a batch that was allowed but not stored, e.g. dropped at a
+// full queue, so that it does not count against the rate limits and the quotas.
+func (w *WriteLimiter) Refund(size int, points int) {
+	w.Lock()
+	defer w.Unlock()
+	w.rollover(time.Now().UTC())
+	w.batches.Give(1)
+	w.bytes.Give(float64(size))
+	if w.usage.Batches > 0 {
+		w.usage.Batches--
+		w.usage.Bytes -= int64(size)
+		w.usage.Points -= int64(points)
+	}
+}
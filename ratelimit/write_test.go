@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteLimiterQuota(t *testing.T) {
+	w := NewWriteLimiter(WriteLimits{DailyBytes: 100, DailyPoints: 10})
+	if ok, _, _ := w.Allow(60, 5); !ok {
+		t.Fatal("First batch should be allowed")
+	}
+	if ok, wait, _ := w.Allow(60, 1); ok || wait <= 0 {
+		t.Errorf("Batch over the daily bytes quota should be rejected with a positive wait. Got: %v, %v", ok, wait)
+	}
+	if ok, _, _ := w.Allow(10, 6); ok {
+		t.Error("Batch over the daily points quota should be rejected")
+	}
+
+	u := w.Usage()
+	if u.Bytes != 60 || u.Points != 5 || u.Batches != 1 || u.Throttled != 2 {
+		t.Errorf("Usage does not match. Got: %+v", u)
+	}
+
+	// Raising the quota at runtime keeps the usage.
+	w.SetLimits(WriteLimits{DailyBytes: 200})
+	if ok, _, _ := w.Allow(60, 100); !ok {
+		t.Error("Batch should be allowed after raising the quota")
+	}
+	if w.Usage().Bytes != 120 {
+		t.Errorf("Usage bytes does not match. Got: %d, Expected: 120", w.Usage().Bytes)
+	}
+
+	// The usage of the day restored after a restart counts against the quota, that of another day does not.
+	w = NewWriteLimiter(WriteLimits{DailyBytes: 100})
+	w.SetUsage(WriteUsage{Day: "2017-06-01", Bytes: 100})
+	if ok, _, _ := w.Allow(60, 1); !ok {
+		t.Error("The usage of another day should be ignored")
+	}
+	w.SetUsage(WriteUsage{Day: time.Now().UTC().Format("2006-01-02"), Bytes: 60})
+	if ok, _, _ := w.Allow(60, 1); ok {
+		t.Error("Batch over the restored usage should be rejected")
+	}
+}
+
+func TestWriteLimiterRate(t *testing.T) {
+	w := NewWriteLimiter(WriteLimits{BatchRate: 2})
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := w.Allow(1, 1); !ok {
+			t.Fatalf("Batch %d should be allowed", i)
+		}
+	}
+	if ok, _, reason := w.Allow(1, 1); ok || reason == "" {
+		t.Error("Batch over the rate limit should be rejected with a reason")
+	}
+}
+
+func TestWriteLimiterBytesRefund(t *testing.T) {
+	w := NewWriteLimiter(WriteLimits{BatchRate: 2, ByteRate: 10})
+	if ok, _, _ := w.Allow(10, 1); !ok {
+		t.Fatalf("First batch should be allowed")
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := w.Allow(5, 1); ok {
+			t.Fatalf("Batch over the bytes rate should be rejected")
+		}
+	}
+	if ok, _, reason := w.Allow(0, 1); !ok {
+		t.Errorf("Batches rejected for bytes should not use the batch rate. Got: %s", reason)
+	}
+}
+
+func TestWriteLimiterSetLimitsKeepsTokens(t *testing.T) {
+	w := NewWriteLimiter(WriteLimits{BatchRate: 2})
+	w.Allow(1, 1)
+	w.Allow(1, 1)
+	w.SetLimits(WriteLimits{BatchRate: 2, DailyBytes: 100})
+	if ok, _, _ := w.Allow(1, 1); ok {
+		t.Error("Setting the same rate should not refill the bucket")
+	}
+	w.SetLimits(WriteLimits{BatchRate: 3, DailyBytes: 100})
+	if ok, _, _ := w.Allow(1, 1); !ok {
+		t.Error("A new rate should start with a full bucket")
+	}
+}
+
+func TestWriteLimiterRefund(t *testing.T) {
+	w := NewWriteLimiter(WriteLimits{BatchRate: 1, DailyBytes: 100, DailyPoints: 10})
+	if ok, _, _ := w.Allow(60, 5); !ok {
+		t.Fatal("First batch should be allowed")
+	}
+	// The batch was dropped, e.g. at a full incoming queue.
+	w.Refund(60, 5)
+	if u := w.Usage(); u.Batches != 0 || u.Bytes != 0 || u.Points != 0 {
+		t.Errorf("Usage should be refunded. Got: %+v", u)
+	}
+	if ok, _, reason := w.Allow(60, 5); !ok {
+		t.Errorf("Batch should be allowed after the refund. Got: %s", reason)
+	}
+}
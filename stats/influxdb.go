@@ -0,0 +1,93 @@
+// Package stats exports various metrics
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package stats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/samitpal/influxdb-router/backends"
+)
+
+// InfluxDB is a Sink writing the metrics in line protocol into the out going queue of a backend,
+// so they are written like the batches of a customer, with retries and health checks.
+type InfluxDB struct {
+	Dest *backends.BackendDest
+}
+
+// NewInfluxDB returns an *InfluxDB enqueueing the metrics to dest.
+func NewInfluxDB(dest *backends.BackendDest) *InfluxDB {
+	return &InfluxDB{Dest: dest}
+}
+
+// tagEscaper escapes measurement names, tag keys and tag values as required by the line protocol.
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func escapeTag(s string) string {
+	return tagEscaper.Replace(s)
+}
+
+// lineProtocol formats the metrics as line protocol. Counters and gauges have a value field,
+// histograms have a count and a sum field.
+func lineProtocol(metrics []Metric, ts time.Time) []byte {
+	var b bytes.Buffer
+	for _, m := range metrics {
+		b.WriteString(escapeTag("influx_router." + m.Name))
+		for _, n := range m.Tags.names() {
+			if m.Tags[n] == "" {
+				continue
+			}
+			b.WriteString("," + escapeTag(n) + "=" + escapeTag(m.Tags[n]))
+		}
+		if m.Kind == Histogram {
+			fmt.Fprintf(&b, " count=%di,sum=%s", m.Count, strconv.FormatFloat(m.Sum, 'f', -1, 64))
+		} else {
+			b.WriteString(" value=" + strconv.FormatFloat(m.Value, 'f', -1, 64))
+		}
+		fmt.Fprintf(&b, " %d\n", ts.UnixNano())
+	}
+	return b.Bytes()
+}
+
+// Flush compresses the metrics and adds them to the out going queue of the backend.
+func (i *InfluxDB) Flush(metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(lineProtocol(metrics, time.Now()))
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	p := &backends.Payload{MessageID: xid.New().String(), Body: b.Bytes(), Points: len(metrics)}
+	if !i.Dest.Enqueue(p) {
+		return fmt.Errorf("out going queue of the metrics backend %s is full", i.Dest.URL)
+	}
+	return nil
+}
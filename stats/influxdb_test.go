@@ -0,0 +1,18 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLineProtocol(t *testing.T) {
+	metrics := []Metric{
+		{Name: "hits", Tags: Tags{"customer": "service x"}, Kind: Counter, Value: 3},
+		{Name: "backend_write_seconds", Tags: Tags{"customer": "servicex"}, Kind: Histogram, Count: 2, Sum: 0.5},
+	}
+	exp := "influx_router.hits,customer=service\\ x value=3 10\n" +
+		"influx_router.backend_write_seconds,customer=servicex count=2i,sum=0.5 10\n"
+	if l := string(lineProtocol(metrics, time.Unix(0, 10))); l != exp {
+		t.Errorf("Line protocol does not match. Got: %s, Expected: %s", l, exp)
+	}
+}
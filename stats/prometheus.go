@@ -0,0 +1,241 @@
+// Package stats exports various metrics
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// promMetric is the prometheus name, help text and constant labels of a metric.
+type promMetric struct {
+	name   string
+	help   string
+	labels Tags
+}
+
+// promMetrics maps the metric names to their prometheus names. Metrics not listed
+// here get the influx_router_ prefix and their dots replaced with underscores.
+var promMetrics = map[string]promMetric{
+	"hits":                  {"influx_router_requests_total", "Write requests received per customer.", nil},
+	"batch-size-bytes":      {"influx_router_received_bytes_total", "Compressed bytes received per customer.", nil},
+	"points":                {"influx_router_received_points_total", "Points received per customer.", nil},
+	"lines":                 {"influx_router_received_lines_total", "Non empty lines received per customer.", nil},
+	"fields":                {"influx_router_received_fields_total", "Fields received per customer.", nil},
+	"uncompressed_bytes":    {"influx_router_received_uncompressed_bytes_total", "Uncompressed bytes received per customer.", nil},
+	"queries":               {"influx_router_queries_total", "Queries received per customer.", nil},
+	"query_cache.hits":      {"influx_router_query_cache_requests_total", "Query cache lookups per customer and result.", Tags{"result": "hit"}},
+	"query_cache.misses":    {"influx_router_query_cache_requests_total", "Query cache lookups per customer and result.", Tags{"result": "miss"}},
+	"write_throttled":       {"influx_router_throttled_total", "Requests rejected by the rate limits and quotas per customer, path and reason.", Tags{"path": "write"}},
+	"query_throttled":       {"influx_router_throttled_total", "Requests rejected by the rate limits and quotas per customer, path and reason.", Tags{"path": "query"}},
+	"dropped_batches":       {"influx_router_dropped_batches_total", "Batches dropped per customer, backend and reason.", nil},
+	"backend_write_seconds": {"influx_router_backend_write_seconds", "Latency of the writes to the backends.", nil},
+	"backend_write_bytes":   {"influx_router_backend_write_bytes", "Compressed size of the batches written to the backends.", nil},
+	"backend_write_points":  {"influx_router_backend_write_points", "Points per batch written to the backends.", nil},
+	"backend_writes":        {"influx_router_backend_writes_total", "Writes to the backends per customer, backend and result.", nil},
+
+	"incoming_queue.current_size":        {"influx_router_incoming_queue_size", "Batches in the incoming queues.", nil},
+	"incoming_queue.limit":               {"influx_router_incoming_queue_limit", "Capacity in batches of the incoming queues.", nil},
+	"incoming_queue.current_bytes":       {"influx_router_incoming_queue_bytes", "Bytes in the incoming queues.", nil},
+	"incoming_queue.limit_bytes":         {"influx_router_incoming_queue_limit_bytes", "Byte limit of the incoming queues, 0 means no limit.", nil},
+	"outgoing_queue.current_size":        {"influx_router_outgoing_queue_size", "Batches in the outgoing queues.", nil},
+	"outgoing_queue.limit":               {"influx_router_outgoing_queue_limit", "Capacity in batches of the outgoing queues.", nil},
+	"outgoing_queue.current_bytes":       {"influx_router_outgoing_queue_bytes", "Bytes in the outgoing queues.", nil},
+	"outgoing_queue.limit_bytes":         {"influx_router_outgoing_queue_limit_bytes", "Byte limit of the outgoing queues, 0 means no limit.", nil},
+	"outgoing_retry_queue.current_size":  {"influx_router_retry_queue_size", "Batches in the retry queues.", nil},
+	"outgoing_retry_queue.limit":         {"influx_router_retry_queue_limit", "Capacity in batches of the retry queues.", nil},
+	"outgoing_retry_queue.current_bytes": {"influx_router_retry_queue_bytes", "Bytes in the retry queues.", nil},
+	"outgoing_retry_queue.limit_bytes":   {"influx_router_retry_queue_limit_bytes", "Byte limit of the retry queues, 0 means no limit.", nil},
+	"backend_health":                     {"influx_router_backend_healthy", "1 if the backend is healthy, 0 otherwise.", nil},
+	"memory_budget.current_bytes":        {"influx_router_memory_budget_bytes", "Bytes held by all the queues.", nil},
+	"memory_budget.limit_bytes":          {"influx_router_memory_budget_limit_bytes", "Byte limit of all the queues, 0 means no limit.", nil},
+	"top_measurements.points":            {"influx_router_top_measurement_points", "Points received by the top measurements of every customer.", nil},
+	"top_measurements.bytes":             {"influx_router_top_measurement_bytes", "Uncompressed bytes received by the top measurements of every customer.", nil},
+
+	"internal_stats.influx_router.uptime":           {"influx_router_uptime_seconds", "Seconds since the process started.", nil},
+	"internal_stats.influx_router.cpu.goroutines":   {"influx_router_goroutines", "Number of goroutines.", nil},
+	"internal_stats.influx_router.mem.alloc":        {"influx_router_mem_alloc_bytes", "Bytes of allocated heap objects.", nil},
+	"internal_stats.influx_router.mem.sys":          {"influx_router_mem_sys_bytes", "Bytes of memory obtained from the OS.", nil},
+	"internal_stats.influx_router.mem.heap.inuse":   {"influx_router_mem_heap_inuse_bytes", "Bytes in in-use heap spans.", nil},
+	"internal_stats.influx_router.mem.heap.objects": {"influx_router_mem_heap_objects", "Number of allocated heap objects.", nil},
+	"internal_stats.influx_router.mem.gc.count":     {"influx_router_gc_count", "Number of completed GC cycles.", nil},
+	"internal_stats.influx_router.mem.gc.next":      {"influx_router_gc_next_bytes", "Heap size target of the next GC cycle.", nil},
+}
+
+// promName returns the prometheus name, help and labels of a metric.
+func promName(m Metric) (string, string, Tags) {
+	labels := Tags{}
+	for k, v := range m.Tags {
+		labels[k] = v
+	}
+	p, ok := promMetrics[m.Name]
+	if !ok {
+		name := "influx_router_" + promEscaper.Replace(m.Name)
+		if m.Kind == Counter {
+			name += "_total"
+		}
+		return name, "", labels
+	}
+	for k, v := range p.labels {
+		labels[k] = v
+	}
+	return p.name, p.help, labels
+}
+
+// promEscaper replaces the characters not allowed in prometheus metric names.
+var promEscaper = strings.NewReplacer(".", "_", "-", "_", ":", "_")
+
+// labelEscaper escapes label values as required by the prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats the labels as {name="value",...} sorted by name.
+func formatLabels(t Tags) string {
+	if len(t) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(t))
+	for _, n := range t.names() {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(t[n])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// with returns a copy of the tags with an extra tag.
+func (t Tags) with(name string, value string) Tags {
+	c := Tags{name: value}
+	for k, v := range t {
+		c[k] = v
+	}
+	return c
+}
+
+// promFamily is a metric family with all its series.
+type promFamily struct {
+	kind   Kind
+	help   string
+	series map[string]*Metric
+}
+
+// Prometheus is a Sink keeping the metrics in memory and serving them in the prometheus text format.
+// Counters and histograms are accumulated over the flushes, gauges keep the value of the last flush.
+type Prometheus struct {
+	sync.Mutex
+	families map[string]*promFamily
+}
+
+// NewPrometheus returns an empty *Prometheus.
+func NewPrometheus() *Prometheus {
+	return &Prometheus{families: make(map[string]*promFamily)}
+}
+
+// Flush adds the metrics of an interval.
+func (p *Prometheus) Flush(metrics []Metric) error {
+	p.Lock()
+	defer p.Unlock()
+
+	// Gauges of customers and backends that went away should not linger.
+	for name, f := range p.families {
+		if f.kind == Gauge {
+			delete(p.families, name)
+		}
+	}
+
+	for _, m := range metrics {
+		name, help, labels := promName(m)
+		f, ok := p.families[name]
+		if !ok {
+			f = &promFamily{kind: m.Kind, help: help, series: make(map[string]*Metric)}
+			p.families[name] = f
+		}
+		k := labels.key()
+		s, ok := f.series[k]
+		if !ok {
+			s = &Metric{Tags: labels, Kind: m.Kind, Bounds: m.Bounds, Buckets: make([]uint64, len(m.Buckets))}
+			f.series[k] = s
+		}
+		switch m.Kind {
+		case Gauge:
+			s.Value = m.Value
+		case Counter:
+			s.Value += m.Value
+		case Histogram:
+			for i, c := range m.Buckets {
+				s.Buckets[i] += c
+			}
+			s.Sum += m.Sum
+			s.Count += m.Count
+		}
+	}
+	return nil
+}
+
+// ServeHTTP writes the metrics in the prometheus text format.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var b bytes.Buffer
+	p.write(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+func (p *Prometheus) write(b *bytes.Buffer) {
+	p.Lock()
+	defer p.Unlock()
+
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := p.families[name]
+		if f.help != "" {
+			fmt.Fprintf(b, "# HELP %s %s\n", name, f.help)
+		}
+		fmt.Fprintf(b, "# TYPE %s %s\n", name, [...]string{"counter", "gauge", "histogram"}[f.kind])
+
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.kind != Histogram {
+				fmt.Fprintf(b, "%s%s %v\n", name, formatLabels(s.Tags), s.Value)
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range s.Bounds {
+				cumulative += s.Buckets[i]
+				fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(s.Tags.with("le", fmt.Sprint(bound))), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(s.Tags.with("le", "+Inf")), s.Count)
+			fmt.Fprintf(b, "%s_sum%s %v\n", name, formatLabels(s.Tags), s.Sum)
+			fmt.Fprintf(b, "%s_count%s %d\n", name, formatLabels(s.Tags), s.Count)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormatLabels(t *testing.T) {
+	l := Tags{"customer": "servicex", "backend": `http://a"b`}
+	exp := `{backend="http://a\"b",customer="servicex"}`
+	if formatLabels(l) != exp {
+		t.Errorf("Labels do not match. Got: %s, Expected: %s", formatLabels(l), exp)
+	}
+}
+
+func TestPrometheusFlush(t *testing.T) {
+	p := NewPrometheus()
+	r := NewRegistry()
+	r.AddSink(p)
+
+	// Counters and histograms accumulate across the flushes.
+	r.Count("hits", Tags{"customer": "servicex"}, 1)
+	r.Observe("backend_write_seconds", Tags{"customer": "servicex"}, latencyBuckets, 0.02)
+	r.Flush()
+	r.Count("hits", Tags{"customer": "servicex"}, 2)
+	r.Count("query_throttled", Tags{"customer": "servicex", "reason": "rate_limit_exceeded"}, 1)
+	r.Observe("backend_write_seconds", Tags{"customer": "servicex"}, latencyBuckets, 20)
+	r.AddGauges(func() []Metric { return []Metric{gauge("backend_health", Tags{"customer": "servicex"}, 1)} })
+	r.Flush()
+
+	var b bytes.Buffer
+	p.write(&b)
+	out := b.String()
+	for _, exp := range []string{
+		"# TYPE influx_router_requests_total counter\n",
+		`influx_router_requests_total{customer="servicex"} 3` + "\n",
+		`influx_router_throttled_total{customer="servicex",path="query",reason="rate_limit_exceeded"} 1` + "\n",
+		"# TYPE influx_router_backend_write_seconds histogram\n",
+		`influx_router_backend_write_seconds_bucket{customer="servicex",le="0.01"} 0` + "\n",
+		`influx_router_backend_write_seconds_bucket{customer="servicex",le="0.025"} 1` + "\n",
+		`influx_router_backend_write_seconds_bucket{customer="servicex",le="10"} 1` + "\n",
+		`influx_router_backend_write_seconds_bucket{customer="servicex",le="+Inf"} 2` + "\n",
+		`influx_router_backend_write_seconds_count{customer="servicex"} 2` + "\n",
+		"# TYPE influx_router_backend_healthy gauge\n",
+		`influx_router_backend_healthy{customer="servicex"} 1` + "\n",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("Output does not contain %q. Got:\n%s", exp, out)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package stats exports various metrics
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package stats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the type of a metric.
+type Kind int
+
+// Metric kinds.
+const (
+	Counter Kind = iota
+	Gauge
+	Histogram
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// SizeBuckets are the upper bounds in bytes of the size histograms.
+var SizeBuckets = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// PointsBuckets are the upper bounds of the histograms of the number of points.
+var PointsBuckets = []float64{10, 100, 500, 1000, 5000, 10000, 50000, 100000}
+
+// Tags are the tags of a metric, e.g. the customer and the backend.
+type Tags map[string]string
+
+// key returns a string identifying the tags, sorted by name.
+func (t Tags) key() string {
+	names := t.names()
+	pairs := make([]string, 0, len(names))
+	for _, n := range names {
+		pairs = append(pairs, n+"="+t[n])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// names returns the tag names in sorted order.
+func (t Tags) names() []string {
+	names := make([]string, 0, len(t))
+	for n := range t {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Metric is a metric aggregated over a flush interval.
+type Metric struct {
+	Name  string
+	Tags  Tags
+	Kind  Kind
+	Value float64 // sum of a counter over the interval or value of a gauge
+
+	// Histograms only.
+	Bounds  []float64 // upper bounds of the buckets
+	Buckets []uint64  // observations per bucket over the interval, the last one is +Inf
+	Sum     float64
+	Count   uint64
+}
+
+// Sink receives the metrics aggregated over every flush interval.
+type Sink interface {
+	Flush(metrics []Metric) error
+}
+
+// Registry aggregates counters and histograms in memory, collects gauges and flushes
+// all of them to the sinks every interval. Counters and histograms are reset on flush.
+type Registry struct {
+	sync.Mutex
+	metrics map[string]*Metric
+	gauges  []func() []Metric
+	sinks   []Sink
+}
+
+// Default is the registry used by Count and Observe.
+var Default = NewRegistry()
+
+// NewRegistry returns an empty *Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*Metric)}
+}
+
+// Count adds v to a counter of the default registry.
+func Count(name string, tags Tags, v float64) {
+	Default.Count(name, tags, v)
+}
+
+// Observe adds an observation to a histogram of the default registry using the latency buckets.
+func Observe(name string, tags Tags, v float64) {
+	Default.Observe(name, tags, latencyBuckets, v)
+}
+
+// AddSink adds a sink the metrics are flushed to.
+func (r *Registry) AddSink(s Sink) {
+	r.Lock()
+	defer r.Unlock()
+	r.sinks = append(r.sinks, s)
+}
+
+// AddGauges adds a function collecting gauges at flush time.
+func (r *Registry) AddGauges(f func() []Metric) {
+	r.Lock()
+	defer r.Unlock()
+	r.gauges = append(r.gauges, f)
+}
+
+// Count adds v to a counter.
+func (r *Registry) Count(name string, tags Tags, v float64) {
+	r.Lock()
+	defer r.Unlock()
+	k := name + "|" + tags.key()
+	m, ok := r.metrics[k]
+	if !ok {
+		m = &Metric{Name: name, Tags: tags, Kind: Counter}
+		r.metrics[k] = m
+	}
+	m.Value += v
+}
+
+// Observe adds an observation to a histogram with the given bucket upper bounds.
+func (r *Registry) Observe(name string, tags Tags, bounds []float64, v float64) {
+	r.Lock()
+	defer r.Unlock()
+	k := name + "|" + tags.key()
+	m, ok := r.metrics[k]
+	if !ok {
+		m = &Metric{Name: name, Tags: tags, Kind: Histogram, Bounds: bounds, Buckets: make([]uint64, len(bounds)+1)}
+		r.metrics[k] = m
+	}
+	i := sort.SearchFloat64s(m.Bounds, v)
+	m.Buckets[i]++
+	m.Sum += v
+	m.Count++
+}
+
+// Flush sends the metrics of the interval to all the sinks and resets the counters and histograms.
+func (r *Registry) Flush() {
+	r.Lock()
+	metrics := make([]Metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, *m)
+	}
+	r.metrics = make(map[string]*Metric)
+	gauges := r.gauges
+	sinks := r.sinks
+	r.Unlock()
+
+	for _, f := range gauges {
+		metrics = append(metrics, f()...)
+	}
+	for _, s := range sinks {
+		if err := s.Flush(metrics); err != nil {
+			log.Errorf("Error flushing metrics: %v", err)
+		}
+	}
+}
+
+// Run flushes the registry every interval.
+func (r *Registry) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.Flush()
+	}
+}
@@ -23,11 +23,7 @@
 package stats
 
 import (
-	"fmt"
-	"io"
-	"net"
 	"runtime"
-	"strings"
 	"time"
 
 	"github.com/samitpal/influxdb-router/backends"
@@ -40,152 +36,137 @@ var (
 	startTime = time.Now()
 )
 
-// Statsd struct holds the statsd data.
-type Statsd struct {
-	Interval int
-	Conn     net.Conn
+func gauge(name string, tags Tags, v float64) Metric {
+	return Metric{Name: name, Tags: tags, Kind: Gauge, Value: v}
 }
 
-// ConnectStatsd connects to a statsd server and returns the connection.
-func ConnectStatsd(s string, p string) (net.Conn, error) {
-	conn, err := net.DialTimeout(p, s, time.Duration(3*time.Second))
-	if err != nil {
-		return nil, err
+// QueueGauges returns the queue, memory budget and backend health gauges.
+func QueueGauges(ingress *backends.Ingress, ac config.APIKeyMap) []Metric {
+	var metrics []Metric
+	metrics = append(metrics,
+		gauge("incoming_queue.current_size", nil, float64(ingress.Len())),
+		gauge("incoming_queue.limit", nil, float64(ingress.Cap)),
+		gauge("memory_budget.current_bytes", nil, float64(backends.GlobalBudget.Used())),
+		gauge("memory_budget.limit_bytes", nil, float64(backends.GlobalBudget.Limit())))
+
+	for _, v := range ac {
+		t := Tags{"customer": v.Name}
+		metrics = append(metrics,
+			gauge("incoming_queue.current_size", t, float64(len(v.IncomingQueue.Queue))),
+			gauge("incoming_queue.limit", t, float64(cap(v.IncomingQueue.Queue))),
+			gauge("incoming_queue.current_bytes", t, float64(v.IncomingQueue.Bytes.Used())),
+			gauge("incoming_queue.limit_bytes", t, float64(v.IncomingQueue.Bytes.Limit())))
+
+		metrics = append(metrics, DestGauges(v)...)
 	}
-	return conn, nil
+	return metrics
 }
 
-// SendStatsdCounterMetric sends a counter metric to statsd
-func (s *Statsd) SendStatsdCounterMetric(m string, v int) error {
-	_, err := fmt.Fprintf(s.Conn, "%s:%d|c", m, v)
-	if err != nil {
-		return err
+// DestGauges returns the out going and retry queue and the health gauges of the backends of a customer.
+func DestGauges(v config.APIKeyConfig) []Metric {
+	var metrics []Metric
+	for _, vd := range v.Dests {
+		bt := Tags{"customer": v.Name, "backend": vd.URL}
+		var h float64
+		if vd.GetHealth() {
+			h = 1
+		}
+		metrics = append(metrics,
+			gauge("outgoing_queue.current_size", bt, float64(len(vd.Queue))),
+			gauge("outgoing_queue.limit", bt, float64(v.OutgoingQueueCap)),
+			gauge("outgoing_queue.current_bytes", bt, float64(vd.QueueBytes.Used())),
+			gauge("outgoing_queue.limit_bytes", bt, float64(vd.QueueBytes.Limit())),
+			gauge("outgoing_retry_queue.current_size", bt, float64(len(vd.RetryQueue))),
+			gauge("outgoing_retry_queue.limit", bt, float64(v.RetryQueueCap)),
+			gauge("outgoing_retry_queue.current_bytes", bt, float64(vd.RetryQueueBytes.Used())),
+			gauge("outgoing_retry_queue.limit_bytes", bt, float64(vd.RetryQueueBytes.Limit())),
+			gauge("backend_health", bt, h))
 	}
-	return nil
+	return metrics
 }
 
-// SendStatsdMetrics sends a bunch of metrics already in statsd format to statsd.
-func (s *Statsd) SendStatsdMetrics(metrics []string) {
-	for _, m := range metrics {
-		io.WriteString(s.Conn, m)
+// UsageGauges returns the points and bytes of the top n measurements of every customer.
+func UsageGauges(ac config.APIKeyMap, n int) []Metric {
+	var metrics []Metric
+	for _, v := range ac {
+		for _, m := range v.Usage.Top(n) {
+			t := Tags{"customer": v.Name, "measurement": m.Name}
+			metrics = append(metrics,
+				gauge("top_measurements.points", t, float64(m.Points)),
+				gauge("top_measurements.bytes", t, float64(m.Bytes)))
+		}
 	}
+	return metrics
 }
 
-// ExportMetrics exports metrics in statsd format
-func ExportMetrics(s *Statsd, incomingQueueCap int, incomingQueue chan *backends.Payload, ac config.APIKeyMap) {
-	interval := time.Tick(time.Duration(s.Interval) * time.Second)
-	for {
-		<-interval
-		var metrics []string
-		// collect metrics
-		incomingQueue := fmt.Sprintf("influx_router.incoming_queue.current_size:%d|g", len(incomingQueue))
-		incomingQueueCap := fmt.Sprintf("influx_router.incoming_queue.limit:%d|g", incomingQueueCap)
-		metrics = append(metrics, incomingQueue, incomingQueueCap)
-
-		for _, v := range ac {
-			svcName := strings.Replace(v.Name, "-", "_", -1)
-			for _, vd := range v.Dests {
-				bURL := strings.TrimPrefix(strings.Replace(vd.URL, ".", "_", -1), "http://")
-				//replace the colon chracter also.
-				bURL = strings.Replace(bURL, ":", "_", -1)
-
-				destQueueSize := fmt.Sprintf("influx_router.%s.outgoing_queue.%s.current_size:%d|g", svcName, bURL, len(vd.Queue))
-				destQueueLimit := fmt.Sprintf("influx_router.%s.outgoing_queue.%s.limit:%d|g", svcName, bURL, v.OutgoingQueueCap)
-
-				destRetryQueueSize := fmt.Sprintf("influx_router.%s.outgoing_retry_queue.%s.current_size:%d|g", svcName, bURL, len(vd.RetryQueue))
-				destRetryQueueLimit := fmt.Sprintf("influx_router.%s.outgoing_retry_queue.%s.limit:%d|g", svcName, bURL, v.RetryQueueCap)
-				metrics = append(metrics, destQueueSize, destQueueLimit, destRetryQueueSize, destRetryQueueLimit)
-
-				vd.RLock()
-				h := vd.GetHealth()
-				vd.RUnlock()
-
-				var ih int
-				if h {
-					ih = 1
-				} else {
-					ih = 0
-				}
-				backendHealth := fmt.Sprintf("influx_router.%s.backend_health.%s:%d|g", svcName, bURL, ih)
-				metrics = append(metrics, backendHealth)
-			}
-		}
-
-		// internal metrics
-		uptimeMetric := uptimeStats()
-		metrics = append(metrics, uptimeMetric...)
-
-		cpuMetrics := internalCPUStats()
-		metrics = append(metrics, cpuMetrics...)
-
-		gcMetrics := internalGCStats()
-		metrics = append(metrics, gcMetrics...)
-
-		memMetrics := internalMemStats()
-		metrics = append(metrics, memMetrics...)
-
-		go s.SendStatsdMetrics(metrics)
-	}
+// RuntimeGauges returns the uptime, cpu, gc and memory stats of the process.
+func RuntimeGauges() []Metric {
+	var metrics []Metric
+	metrics = append(metrics, uptimeStats()...)
+	metrics = append(metrics, internalCPUStats()...)
+	metrics = append(metrics, internalGCStats()...)
+	metrics = append(metrics, internalMemStats()...)
+	return metrics
 }
 
-func gaugeFunc(m string, v interface{}) string {
-	return fmt.Sprintf("influx_router.internal_stats.%s:%v|g", m, v)
+func gaugeFunc(m string, v float64) Metric {
+	return gauge("internal_stats."+m, nil, v)
 }
 
-func uptimeStats() []string {
-	var m []string
-	m = append(m, gaugeFunc("influx_router.uptime", int64(time.Since(startTime).Seconds())))
+func uptimeStats() []Metric {
+	var m []Metric
+	m = append(m, gaugeFunc("influx_router.uptime", float64(int64(time.Since(startTime).Seconds()))))
 	return m
 }
 
-func internalCPUStats() []string {
-	var m []string
-	m = append(m, gaugeFunc("influx_router.cpu.goroutines", uint64(runtime.NumGoroutine())))
-	m = append(m, gaugeFunc("influx_router.cpu.cgo_calls", uint64(runtime.NumCgoCall())))
+func internalCPUStats() []Metric {
+	var m []Metric
+	m = append(m, gaugeFunc("influx_router.cpu.goroutines", float64(runtime.NumGoroutine())))
+	m = append(m, gaugeFunc("influx_router.cpu.cgo_calls", float64(runtime.NumCgoCall())))
 	return m
 }
 
-func internalGCStats() []string {
-	var m []string
+func internalGCStats() []Metric {
+	var m []Metric
 	ms := &runtime.MemStats{}
 	runtime.ReadMemStats(ms)
-	m = append(m, gaugeFunc("influx_router.mem.gc.sys", ms.GCSys))
-	m = append(m, gaugeFunc("influx_router.mem.gc.next", ms.NextGC))
-	m = append(m, gaugeFunc("influx_router.mem.gc.last", ms.LastGC))
-	m = append(m, gaugeFunc("influx_router.mem.gc.pause_total", ms.PauseTotalNs))
-	m = append(m, gaugeFunc("influx_router.mem.gc.pause", ms.PauseNs[(ms.NumGC+255)%256]))
-	m = append(m, gaugeFunc("influx_router.mem.gc.count", uint64(ms.NumGC)))
+	m = append(m, gaugeFunc("influx_router.mem.gc.sys", float64(ms.GCSys)))
+	m = append(m, gaugeFunc("influx_router.mem.gc.next", float64(ms.NextGC)))
+	m = append(m, gaugeFunc("influx_router.mem.gc.last", float64(ms.LastGC)))
+	m = append(m, gaugeFunc("influx_router.mem.gc.pause_total", float64(ms.PauseTotalNs)))
+	m = append(m, gaugeFunc("influx_router.mem.gc.pause", float64(ms.PauseNs[(ms.NumGC+255)%256])))
+	m = append(m, gaugeFunc("influx_router.mem.gc.count", float64(ms.NumGC)))
 	return m
 }
 
-func internalMemStats() []string {
-	var m []string
+func internalMemStats() []Metric {
+	var m []Metric
 	ms := &runtime.MemStats{}
 	runtime.ReadMemStats(ms)
-	m = append(m, gaugeFunc("influx_router.mem.alloc", ms.Alloc))
-	m = append(m, gaugeFunc("influx_router.mem.total", ms.TotalAlloc))
-	m = append(m, gaugeFunc("influx_router.mem.sys", ms.Sys))
-	m = append(m, gaugeFunc("influx_router.mem.lookups", ms.Lookups))
-	m = append(m, gaugeFunc("influx_router.mem.malloc", ms.Mallocs))
-	m = append(m, gaugeFunc("influx_router.mem.frees", ms.Frees))
+	m = append(m, gaugeFunc("influx_router.mem.alloc", float64(ms.Alloc)))
+	m = append(m, gaugeFunc("influx_router.mem.total", float64(ms.TotalAlloc)))
+	m = append(m, gaugeFunc("influx_router.mem.sys", float64(ms.Sys)))
+	m = append(m, gaugeFunc("influx_router.mem.lookups", float64(ms.Lookups)))
+	m = append(m, gaugeFunc("influx_router.mem.malloc", float64(ms.Mallocs)))
+	m = append(m, gaugeFunc("influx_router.mem.frees", float64(ms.Frees)))
 
 	// Heap
-	m = append(m, gaugeFunc("influx_router.mem.heap.alloc", ms.HeapAlloc))
-	m = append(m, gaugeFunc("influx_router.mem.heap.sys", ms.HeapSys))
-	m = append(m, gaugeFunc("influx_router.mem.heap.idle", ms.HeapIdle))
-	m = append(m, gaugeFunc("influx_router.mem.heap.inuse", ms.HeapInuse))
-	m = append(m, gaugeFunc("influx_router.mem.heap.released", ms.HeapReleased))
-	m = append(m, gaugeFunc("influx_router.mem.heap.objects", ms.HeapObjects))
+	m = append(m, gaugeFunc("influx_router.mem.heap.alloc", float64(ms.HeapAlloc)))
+	m = append(m, gaugeFunc("influx_router.mem.heap.sys", float64(ms.HeapSys)))
+	m = append(m, gaugeFunc("influx_router.mem.heap.idle", float64(ms.HeapIdle)))
+	m = append(m, gaugeFunc("influx_router.mem.heap.inuse", float64(ms.HeapInuse)))
+	m = append(m, gaugeFunc("influx_router.mem.heap.released", float64(ms.HeapReleased)))
+	m = append(m, gaugeFunc("influx_router.mem.heap.objects", float64(ms.HeapObjects)))
 
 	// Stack
-	m = append(m, gaugeFunc("influx_router.mem.stack.inuse", ms.StackInuse))
-	m = append(m, gaugeFunc("influx_router.mem.stack.sys", ms.StackSys))
-	m = append(m, gaugeFunc("influx_router.mem.stack.mspan_inuse", ms.MSpanInuse))
-	m = append(m, gaugeFunc("influx_router.mem.stack.mspan_sys", ms.MSpanSys))
-	m = append(m, gaugeFunc("influx_router.mem.stack.mcache_inuse", ms.MCacheInuse))
-	m = append(m, gaugeFunc("influx_router.mem.stack.mcache_sys", ms.MCacheSys))
-
-	m = append(m, gaugeFunc("influx_router.mem.othersys", ms.OtherSys))
+	m = append(m, gaugeFunc("influx_router.mem.stack.inuse", float64(ms.StackInuse)))
+	m = append(m, gaugeFunc("influx_router.mem.stack.sys", float64(ms.StackSys)))
+	m = append(m, gaugeFunc("influx_router.mem.stack.mspan_inuse", float64(ms.MSpanInuse)))
+	m = append(m, gaugeFunc("influx_router.mem.stack.mspan_sys", float64(ms.MSpanSys)))
+	m = append(m, gaugeFunc("influx_router.mem.stack.mcache_inuse", float64(ms.MCacheInuse)))
+	m = append(m, gaugeFunc("influx_router.mem.stack.mcache_sys", float64(ms.MCacheSys)))
+
+	m = append(m, gaugeFunc("influx_router.mem.othersys", float64(ms.OtherSys)))
 
 	return m
 }
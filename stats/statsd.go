@@ -0,0 +1,260 @@
+// Package stats exports various metrics
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statsd formats.
+const (
+	StatsdPlain     = "plain"     // tags are part of the metric name
+	StatsdDogStatsd = "dogstatsd" // name:value|type|#tag:value,...
+	StatsdInflux    = "influx"    // name,tag=value,...:value|type
+)
+
+// Statsd defaults.
+const (
+	defaultStatsdMTU         = 1432  // fits in an ethernet frame with the ip and udp headers
+	defaultStatsdMaxBuffered = 10000 // lines kept while statsd is not reachable
+	maxStatsdReconnectWait   = 30 * time.Second
+)
+
+// Statsd is a Sink sending the metrics to a statsd server over udp, tcp or a unix socket.
+// The lines are packed into packets of at most MTU bytes. While statsd is not reachable the
+// most recent lines are buffered and the connection is retried in the background.
+type Statsd struct {
+	sync.Mutex
+	Addr        string
+	Network     string // udp, tcp, unix or unixgram
+	Format      string
+	MTU         int
+	MaxBuffered int
+
+	conn         net.Conn
+	reconnecting bool
+	buffered     []string
+}
+
+// NewStatsd returns a *Statsd and starts connecting to the statsd server in the background.
+func NewStatsd(addr string, network string, format string, mtu int) *Statsd {
+	if mtu <= 0 {
+		mtu = defaultStatsdMTU
+	}
+	s := &Statsd{Addr: addr, Network: network, Format: format, MTU: mtu, MaxBuffered: defaultStatsdMaxBuffered}
+	s.Lock()
+	s.reconnect()
+	s.Unlock()
+	return s
+}
+
+// ConnectStatsd connects to a statsd server and returns the connection.
+func ConnectStatsd(s string, p string) (net.Conn, error) {
+	conn, err := net.DialTimeout(p, s, time.Duration(3*time.Second))
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// reconnect starts a goroutine connecting to statsd with a backoff unless one is already running.
+// It must be called with the lock held.
+func (s *Statsd) reconnect() {
+	if s.reconnecting {
+		return
+	}
+	s.reconnecting = true
+	go func() {
+		wait := time.Second
+		for {
+			conn, err := ConnectStatsd(s.Addr, s.Network)
+			if err == nil {
+				log.Infof("Connected to statsd server %s/%s", s.Network, s.Addr)
+				s.Lock()
+				s.conn = conn
+				s.reconnecting = false
+				s.Unlock()
+				return
+			}
+			log.Errorf("Error connecting to statsd server: %v. Retrying in %v", err, wait)
+			time.Sleep(wait)
+			if wait *= 2; wait > maxStatsdReconnectWait {
+				wait = maxStatsdReconnectWait
+			}
+		}
+	}()
+}
+
+// Flush sends the buffered lines and the metrics to statsd. If statsd is not reachable the lines
+// are buffered, dropping the oldest ones over MaxBuffered.
+func (s *Statsd) Flush(metrics []Metric) error {
+	s.Lock()
+	defer s.Unlock()
+
+	lines := s.buffered
+	for _, m := range metrics {
+		lines = append(lines, s.lines(m)...)
+	}
+	s.buffered = nil
+
+	if s.conn == nil {
+		s.buffer(lines)
+		s.reconnect()
+		return nil
+	}
+
+	stream := s.Network == "tcp" || s.Network == "unix"
+	packets := pack(lines, s.MTU, stream)
+	for i, p := range packets {
+		if _, err := s.conn.Write(p.data); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			s.buffer(lines[packets[i].first:])
+			s.reconnect()
+			return err
+		}
+	}
+	return nil
+}
+
+// buffer keeps the last MaxBuffered lines.
+func (s *Statsd) buffer(lines []string) {
+	if len(lines) > s.MaxBuffered {
+		lines = lines[len(lines)-s.MaxBuffered:]
+	}
+	s.buffered = lines
+}
+
+// packet is a packet of newline separated lines starting with the line at index first.
+type packet struct {
+	data  []byte
+	first int
+}
+
+// pack packs the lines into packets of at most mtu bytes. A line longer than mtu is sent in a
+// packet of its own. On stream connections every line ends with a newline.
+func pack(lines []string, mtu int, stream bool) []packet {
+	var packets []packet
+	var b bytes.Buffer
+	first := 0
+	for i, l := range lines {
+		size := len(l)
+		if b.Len() > 0 || stream {
+			size++
+		}
+		if b.Len() > 0 && b.Len()+size > mtu {
+			packets = append(packets, packet{data: append([]byte(nil), b.Bytes()...), first: first})
+			b.Reset()
+			first = i
+		}
+		if b.Len() > 0 && !stream {
+			b.WriteByte('\n')
+		}
+		b.WriteString(l)
+		if stream {
+			b.WriteByte('\n')
+		}
+	}
+	if b.Len() > 0 {
+		packets = append(packets, packet{data: append([]byte(nil), b.Bytes()...), first: first})
+	}
+	return packets
+}
+
+// lines formats a metric. Histograms are sent as a count and a sum counter.
+func (s *Statsd) lines(m Metric) []string {
+	switch m.Kind {
+	case Counter:
+		return []string{s.format(m.Name, m.Tags, m.Value, "c")}
+	case Gauge:
+		return []string{s.format(m.Name, m.Tags, m.Value, "g")}
+	default:
+		return []string{
+			s.format(m.Name+".count", m.Tags, float64(m.Count), "c"),
+			s.format(m.Name+".sum", m.Tags, m.Sum, "c"),
+		}
+	}
+}
+
+func (s *Statsd) format(name string, tags Tags, v float64, typ string) string {
+	value := strconv.FormatFloat(v, 'f', -1, 64)
+	switch s.Format {
+	case StatsdDogStatsd:
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "influx_router.%s:%s|%s", name, value, typ)
+		for i, n := range tags.names() {
+			if i == 0 {
+				b.WriteString("|#")
+			} else {
+				b.WriteString(",")
+			}
+			b.WriteString(n + ":" + tags[n])
+		}
+		return b.String()
+	case StatsdInflux:
+		var b bytes.Buffer
+		b.WriteString("influx_router." + name)
+		for _, n := range tags.names() {
+			b.WriteString("," + escapeTag(n) + "=" + escapeTag(statsdTagValue(tags[n])))
+		}
+		fmt.Fprintf(&b, ":%s|%s", value, typ)
+		return b.String()
+	default:
+		return fmt.Sprintf("%s:%s|%s", plainName(name, tags), value, typ)
+	}
+}
+
+// plainName puts the customer and the backend tags in the metric name the way the router always did:
+// influx_router.<customer>.<first part of the name>.<backend>.<rest of the name>. The values of the
+// other tags, e.g. the result of a write, are appended sorted by tag name.
+func plainName(name string, tags Tags) string {
+	for _, n := range tags.names() {
+		if n != "customer" && n != "backend" {
+			name += "." + strings.Replace(tags[n], ".", "_", -1)
+		}
+	}
+	if b, ok := tags["backend"]; ok {
+		b = strings.TrimPrefix(strings.Replace(b, ".", "_", -1), "http://")
+		//replace the colon chracter also.
+		b = strings.Replace(b, ":", "_", -1)
+		parts := strings.SplitN(name, ".", 2)
+		parts = append(parts[:1], append([]string{b}, parts[1:]...)...)
+		name = strings.Join(parts, ".")
+	}
+	if c, ok := tags["customer"]; ok {
+		name = strings.Replace(c, "-", "_", -1) + "." + name
+	}
+	return "influx_router." + name
+}
+
+// statsdTagValue strips the scheme of an url and replaces the colons statsd uses as separator.
+func statsdTagValue(v string) string {
+	v = strings.TrimPrefix(strings.TrimPrefix(v, "http://"), "https://")
+	return strings.Replace(v, ":", "_", -1)
+}
@@ -0,0 +1,76 @@
+package stats
+
+import (
+	"net"
+	"testing"
+)
+
+func TestStatsdFormat(t *testing.T) {
+	tags := Tags{"customer": "service-x", "backend": "http://127.0.0.1:8086"}
+	tests := []struct {
+		format string
+		name   string
+		exp    string
+	}{
+		{StatsdPlain, "outgoing_queue.current_size", "influx_router.service_x.outgoing_queue.127_0_0_1_8086.current_size:5|g"},
+		{StatsdPlain, "backend_health", "influx_router.service_x.backend_health.127_0_0_1_8086:5|g"},
+		{StatsdDogStatsd, "backend_health", "influx_router.backend_health:5|g|#backend:http://127.0.0.1:8086,customer:service-x"},
+		{StatsdInflux, "backend_health", `influx_router.backend_health,backend=127.0.0.1_8086,customer=service-x:5|g`},
+	}
+	for _, tt := range tests {
+		s := &Statsd{Format: tt.format}
+		if l := s.format(tt.name, tags, 5, "g"); l != tt.exp {
+			t.Errorf("Statsd line does not match for format %s. Got: %s, Expected: %s", tt.format, l, tt.exp)
+		}
+	}
+
+	s := &Statsd{}
+	if l := s.format("backend_writes", tags.with("result", "success"), 5, "c"); l != "influx_router.service_x.backend_writes.127_0_0_1_8086.success:5|c" {
+		t.Errorf("Statsd line does not match. Got: %s", l)
+	}
+	if l := s.format("internal_stats.influx_router.uptime", nil, 12, "g"); l != "influx_router.internal_stats.influx_router.uptime:12|g" {
+		t.Errorf("Statsd line does not match. Got: %s", l)
+	}
+}
+
+func TestPack(t *testing.T) {
+	lines := []string{"a:1|c", "b:2|c", "c:3|c", "a_very_long_metric_name:4|c"}
+
+	packets := pack(lines, 11, false)
+	exp := []string{"a:1|c\nb:2|c", "c:3|c", "a_very_long_metric_name:4|c"}
+	if len(packets) != len(exp) {
+		t.Fatalf("Number of packets does not match. Got: %d, Expected: %d", len(packets), len(exp))
+	}
+	for i, p := range packets {
+		if string(p.data) != exp[i] {
+			t.Errorf("Packet does not match. Got: %q, Expected: %q", p.data, exp[i])
+		}
+	}
+	if packets[2].first != 3 {
+		t.Errorf("First line of the packet does not match. Got: %d, Expected: %d", packets[2].first, 3)
+	}
+
+	packets = pack(lines[:2], 100, true)
+	if len(packets) != 1 || string(packets[0].data) != "a:1|c\nb:2|c\n" {
+		t.Errorf("Stream packet does not match. Got: %v", packets)
+	}
+}
+
+func TestStatsdBuffer(t *testing.T) {
+	s := &Statsd{Addr: "127.0.0.1:0", Network: "udp", MTU: defaultStatsdMTU, MaxBuffered: 2, reconnecting: true}
+	s.Flush([]Metric{{Name: "a", Kind: Counter, Value: 1}, {Name: "b", Kind: Counter, Value: 1}, {Name: "c", Kind: Counter, Value: 1}})
+	exp := []string{"influx_router.b:1|c", "influx_router.c:1|c"}
+	if len(s.buffered) != 2 || s.buffered[0] != exp[0] || s.buffered[1] != exp[1] {
+		t.Errorf("Buffered lines do not match. Got: %v, Expected: %v", s.buffered, exp)
+	}
+
+	c, srv := net.Pipe()
+	defer srv.Close()
+	s.conn = c
+	go s.Flush(nil)
+	buf := make([]byte, 100)
+	n, _ := srv.Read(buf)
+	if string(buf[:n]) != "influx_router.b:1|c\ninflux_router.c:1|c" {
+		t.Errorf("Flushed packet does not match. Got: %q", buf[:n])
+	}
+}
@@ -0,0 +1,188 @@
+// Package tail streams samples of the batches received from the customers
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/samitpal/influxdb-router/ratelimit"
+	"github.com/samitpal/influxdb-router/usage"
+)
+
+// tailBuffer is the number of lines buffered for a tail that is not read fast enough.
+const tailBuffer = 1000
+
+// maxDecompressions is the number of batches decompressed for the tails at a time.
+const maxDecompressions = 2
+
+// ErrTooManyTails is returned when the max number of tails is reached.
+var ErrTooManyTails = errors.New("too many tails")
+
+// Filter selects the lines streamed to a tail.
+type Filter struct {
+	Measurement string  // only the lines of this measurement, all if empty
+	Sample      float64 // ratio of the batches sampled, from 0 to 1
+	Rate        float64 // max lines per second
+}
+
+// Tail is a stream of the lines of the batches of a customer.
+type Tail struct {
+	dropped  int64 // first for 64-bit alignment of atomic operations
+	Lines    chan string
+	customer string
+	filter   Filter
+	limiter  *ratelimit.TokenBucket
+}
+
+// Dropped returns the number of lines over the rate or the buffer of the tail that were dropped. The rest of a
+// batch that reached the rate of the tail is not read, so it is not counted.
+func (t *Tail) Dropped() int64 {
+	return atomic.LoadInt64(&t.dropped)
+}
+
+// ready tells whether the tail can take a line now, without spending it.
+func (t *Tail) ready() bool {
+	ok, _ := t.limiter.Take(1)
+	if ok {
+		t.limiter.Give(1)
+	}
+	return ok
+}
+
+// Hub passes the batches received from the customers to their tails.
+type Hub struct {
+	sync.RWMutex
+	MaxTails       int
+	tails          map[string]map[*Tail]bool // by customer
+	n              int
+	decompressions *ratelimit.Concurrency
+}
+
+// Default is the hub the listener publishes to.
+var Default = NewHub(5)
+
+// NewHub returns a *Hub allowing at most maxTails tails at a time.
+func NewHub(maxTails int) *Hub {
+	return &Hub{
+		MaxTails:       maxTails,
+		tails:          make(map[string]map[*Tail]bool),
+		decompressions: ratelimit.NewConcurrency(maxDecompressions),
+	}
+}
+
+// Subscribe starts a tail of the batches of a customer.
+func (h *Hub) Subscribe(customer string, f Filter) (*Tail, error) {
+	h.Lock()
+	defer h.Unlock()
+	if h.n >= h.MaxTails {
+		return nil, ErrTooManyTails
+	}
+	t := &Tail{
+		Lines:    make(chan string, tailBuffer),
+		customer: customer,
+		filter:   f,
+		limiter:  ratelimit.NewTokenBucket(f.Rate, f.Rate),
+	}
+	if h.tails[customer] == nil {
+		h.tails[customer] = make(map[*Tail]bool)
+	}
+	h.tails[customer][t] = true
+	h.n++
+	return t, nil
+}
+
+// Unsubscribe ends a tail.
+func (h *Hub) Unsubscribe(t *Tail) {
+	h.Lock()
+	defer h.Unlock()
+	if h.tails[t.customer][t] {
+		delete(h.tails[t.customer], t)
+		h.n--
+	}
+	if len(h.tails[t.customer]) == 0 {
+		delete(h.tails, t.customer)
+	}
+}
+
+// Publish passes a gzip compressed batch of a customer to its tails. It returns right away, the batch is
+// decompressed in the background and only if a tail samples it and has not used up its rate. The batches
+// that would exceed maxDecompressions are skipped.
+func (h *Hub) Publish(customer string, compressed []byte) {
+	h.RLock()
+	var sampled []*Tail
+	for t := range h.tails[customer] {
+		if rand.Float64() < t.filter.Sample && t.ready() {
+			sampled = append(sampled, t)
+		}
+	}
+	h.RUnlock()
+	if len(sampled) == 0 || !h.decompressions.TryAcquire() {
+		return
+	}
+	go func() {
+		defer h.decompressions.Release()
+		publish(sampled, compressed)
+	}()
+}
+
+// publish streams the lines of a batch matching the filters of the tails. A tail that used up its rate
+// skips the rest of the batch, the batch is not read further once all the tails have.
+func publish(tails []*Tail, compressed []byte) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return
+	}
+	defer zr.Close()
+	s := bufio.NewScanner(zr)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	exhausted := make([]bool, len(tails))
+	active := len(tails)
+	for active > 0 && s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		for i, t := range tails {
+			if exhausted[i] || (t.filter.Measurement != "" && string(usage.MeasurementName(line)) != t.filter.Measurement) {
+				continue
+			}
+			if ok, _ := t.limiter.Take(1); !ok {
+				atomic.AddInt64(&t.dropped, 1)
+				exhausted[i] = true
+				active--
+				continue
+			}
+			select {
+			case t.Lines <- string(line):
+			default:
+				atomic.AddInt64(&t.dropped, 1)
+			}
+		}
+	}
+}
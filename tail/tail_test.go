@@ -0,0 +1,96 @@
+package tail
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+)
+
+func gzipped(s string) []byte {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.Bytes()
+}
+
+func receive(t *Tail, n int) []string {
+	var got []string
+	for i := 0; i < n; i++ {
+		select {
+		case l := <-t.Lines:
+			got = append(got, l)
+		case <-time.After(time.Second):
+			return got
+		}
+	}
+	return got
+}
+
+func TestPublish(t *testing.T) {
+	h := NewHub(5)
+	tl, err := h.Subscribe("a", Filter{Measurement: "cpu", Sample: 1, Rate: 100})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	h.Publish("b", gzipped("cpu value=1\n"))
+	h.Publish("a", gzipped("# comment\ncpu,host=a value=1\nmem value=2\n\ncpu value=3\n"))
+
+	got := receive(tl, 2)
+	if len(got) != 2 || got[0] != "cpu,host=a value=1" || got[1] != "cpu value=3" {
+		t.Errorf("Tail should get the cpu lines of a. Got: %v, Expected: [cpu,host=a value=1 cpu value=3]", got)
+	}
+}
+
+func TestRate(t *testing.T) {
+	h := NewHub(5)
+	tl, _ := h.Subscribe("a", Filter{Sample: 1, Rate: 2})
+	h.Publish("a", gzipped("cpu value=1\ncpu value=2\ncpu value=3\ncpu value=4\n"))
+
+	if got := receive(tl, 2); len(got) != 2 {
+		t.Errorf("Wrong number of lines streamed. Got: %d, Expected: 2", len(got))
+	}
+	// The batch is not read further once the tail reached its rate.
+	if d := tl.Dropped(); d != 1 {
+		t.Errorf("Wrong number of lines dropped. Got: %d, Expected: 1", d)
+	}
+}
+
+func TestPublishBounded(t *testing.T) {
+	h := NewHub(5)
+	tl, _ := h.Subscribe("a", Filter{Sample: 1, Rate: 100})
+	for i := 0; i < maxDecompressions; i++ {
+		h.decompressions.TryAcquire()
+	}
+	h.Publish("a", gzipped("cpu value=1\n"))
+	select {
+	case l := <-tl.Lines:
+		t.Errorf("A batch over the max decompressions should be skipped. Got: %s", l)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	h.decompressions.Release()
+	tl.limiter.Take(100)
+	h.Publish("a", gzipped("cpu value=1\n"))
+	select {
+	case l := <-tl.Lines:
+		t.Errorf("A batch over the rate of the tail should be skipped. Got: %s", l)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if n := h.decompressions.InFlight(); n != maxDecompressions-1 {
+		t.Errorf("Skipped batches should not be decompressed. Got: %d, Expected: %d", n, maxDecompressions-1)
+	}
+}
+
+func TestMaxTails(t *testing.T) {
+	h := NewHub(1)
+	tl, _ := h.Subscribe("a", Filter{Sample: 1})
+	if _, err := h.Subscribe("b", Filter{Sample: 1}); err != ErrTooManyTails {
+		t.Errorf("Wrong error over the max tails. Got: %v, Expected: %v", err, ErrTooManyTails)
+	}
+	h.Unsubscribe(tl)
+	if _, err := h.Subscribe("b", Filter{Sample: 1}); err != nil {
+		t.Errorf("Subscribe should succeed after an unsubscribe. Got: %v", err)
+	}
+}
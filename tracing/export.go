@@ -0,0 +1,156 @@
+// Package tracing traces the batches through the router.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceName is the service.name resource attribute of the spans.
+const serviceName = "influxdb-router"
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// toOTLP converts a span to its OTLP json representation.
+func toOTLP(s *Span) otlpSpan {
+	s.Lock()
+	defer s.Unlock()
+	o := otlpSpan{
+		TraceID:           s.Context.TraceID.String(),
+		SpanID:            s.Context.SpanID.String(),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+	if s.Parent != (SpanID{}) {
+		o.ParentSpanID = s.Parent.String()
+	}
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o.Attributes = append(o.Attributes, otlpAttribute{Key: k, Value: otlpValue{StringValue: s.Attributes[k]}})
+	}
+	if s.Error != "" {
+		o.Status = otlpStatus{Code: 2, Message: s.Error}
+	}
+	return o
+}
+
+// OTLP exports the spans to an OpenTelemetry collector with OTLP over http and json.
+type OTLP struct {
+	Endpoint string // e.g. http://localhost:4318
+	Client   *http.Client
+}
+
+// NewOTLP returns an *OTLP exporting to the collector at endpoint.
+func NewOTLP(endpoint string) *OTLP {
+	return &OTLP{Endpoint: strings.TrimSuffix(endpoint, "/"), Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Export posts the spans to the /v1/traces endpoint of the collector.
+func (o *OTLP) Export(spans []*Span) error {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		otlpSpans = append(otlpSpans, toOTLP(s))
+	}
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: serviceName}}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": serviceName},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := o.Client.Post(o.Endpoint+"/v1/traces", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector %s returned %s", o.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// Writer exports the spans as json, one span per line, e.g. to stdout or a file for local debugging.
+type Writer struct {
+	sync.Mutex
+	W io.Writer
+}
+
+// Export writes the spans.
+func (w *Writer) Export(spans []*Span) error {
+	w.Lock()
+	defer w.Unlock()
+	enc := json.NewEncoder(w.W)
+	for _, s := range spans {
+		if err := enc.Encode(toOTLP(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
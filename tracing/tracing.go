@@ -0,0 +1,318 @@
+// Package tracing traces the batches through the router.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samitpal/influxdb-router/logging"
+)
+
+var log = logging.For("tracing")
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext is what is propagated to the children of a span.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Valid reports whether the span context has a trace and a span id.
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header.
+func (sc SpanContext) Traceparent() string {
+	if !sc.Valid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header.
+func ParseTraceparent(h string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// Version 00 has exactly four parts, later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.Valid()
+}
+
+// Span is an operation of a trace. A nil *Span, or a span of a trace that is not sampled, records
+// nothing, so they can be used as any other span. A span that is not sampled still propagates its context.
+type Span struct {
+	sync.Mutex
+	Name       string
+	Context    SpanContext
+	Parent     SpanID
+	Kind       int // 1 internal, 2 server, 3 client, as in OTLP
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string
+
+	tracer *Tracer
+}
+
+// Span kinds.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// SpanContext returns the context of the span, an empty one for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// recording tells whether the span is exported.
+func (s *Span) recording() bool {
+	return s != nil && s.Context.Sampled && s.tracer != nil
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(k string, v string) {
+	if !s.recording() {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.Attributes[k] = v
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(msg string) {
+	if !s.recording() {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.Error = msg
+}
+
+// Finish ends the span now and hands it to the exporter.
+func (s *Span) Finish() {
+	s.FinishAt(time.Now())
+}
+
+// FinishAt ends the span at t and hands it to the exporter.
+func (s *Span) FinishAt(t time.Time) {
+	if !s.recording() {
+		return
+	}
+	s.Lock()
+	s.End = t
+	s.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracer creates the spans and exports them in batches. A nil *Tracer creates no spans.
+type Tracer struct {
+	exporter    Exporter
+	sampleRatio float64
+	spans       chan *Span
+	flush       chan chan struct{}
+}
+
+// Default is the tracer used by Start and StartAt, nil till tracing is enabled.
+var Default *Tracer
+
+// NewTracer returns a *Tracer sampling sampleRatio of the traces that do not come with a
+// sampling decision and starts exporting the spans with the exporter.
+func NewTracer(exporter Exporter, sampleRatio float64) *Tracer {
+	t := &Tracer{exporter: exporter, sampleRatio: sampleRatio, spans: make(chan *Span, 4096), flush: make(chan chan struct{})}
+	go t.run(512, 5*time.Second)
+	return t
+}
+
+// Start starts a span of the default tracer.
+func Start(name string, kind int, parent SpanContext) *Span {
+	return Default.StartAt(name, kind, parent, time.Now())
+}
+
+// StartAt starts a span of the default tracer that started at t.
+func StartAt(name string, kind int, parent SpanContext, t time.Time) *Span {
+	return Default.StartAt(name, kind, parent, t)
+}
+
+// StartChild starts a span of the default tracer continuing the trace of parent.
+// It returns nil if parent is not valid, e.g. for batches that were not traced.
+func StartChild(name string, kind int, parent SpanContext) *Span {
+	return StartChildAt(name, kind, parent, time.Now())
+}
+
+// StartChildAt is StartChild for a span that started at t.
+func StartChildAt(name string, kind int, parent SpanContext, t time.Time) *Span {
+	if !parent.Valid() {
+		return nil
+	}
+	return Default.StartAt(name, kind, parent, t)
+}
+
+// StartAt starts a span that started at t, the child of parent if it is valid.
+// The trace is sampled if parent is, otherwise by the sample ratio of the tracer. If the trace is not
+// sampled the span records nothing and propagates the context of parent unchanged,
+// or a new context if parent is not valid. It returns nil if the tracer is nil and parent is not valid.
+func (t *Tracer) StartAt(name string, kind int, parent SpanContext, start time.Time) *Span {
+	if t == nil {
+		if parent.Valid() {
+			return &Span{Name: name, Context: parent}
+		}
+		return nil
+	}
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.Valid() {
+		rand.Read(sc.TraceID[:])
+	}
+	// The sample ratio applies unless the parent is sampled.
+	if !sc.Sampled {
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+	if !sc.Sampled {
+		if parent.Valid() {
+			return &Span{Name: name, Context: parent}
+		}
+		rand.Read(sc.SpanID[:])
+		return &Span{Name: name, Context: sc}
+	}
+	rand.Read(sc.SpanID[:])
+	return &Span{Name: name, Context: sc, Parent: parent.SpanID, Kind: kind, Start: start, Attributes: make(map[string]string), tracer: t}
+}
+
+// sample decides from the trace id whether a new trace is sampled.
+func (t *Tracer) sample(id TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < t.sampleRatio
+}
+
+// enqueue adds a finished span to the export queue, dropping it if the queue is full.
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+	}
+}
+
+// Flush exports the spans finished so far, waiting at most timeout.
+func (t *Tracer) Flush(timeout time.Duration) {
+	if t == nil {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+	case <-timer.C:
+		return
+	}
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+// run exports the spans in batches of up to size spans at least every interval, or when flushed.
+func (t *Tracer) run(size int, interval time.Duration) {
+	tick := time.Tick(interval)
+	batch := make([]*Span, 0, size)
+	for {
+		var flushed chan struct{}
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) < size {
+				continue
+			}
+		case <-tick:
+			if len(batch) == 0 {
+				continue
+			}
+		case flushed = <-t.flush:
+			for queued := true; queued; {
+				select {
+				case s := <-t.spans:
+					batch = append(batch, s)
+				default:
+					queued = false
+				}
+			}
+		}
+		if len(batch) > 0 {
+			if err := t.exporter.Export(batch); err != nil {
+				log.Errorf("Error exporting %d spans: %v", len(batch), err)
+			}
+			batch = make([]*Span, 0, size)
+		}
+		if flushed != nil {
+			close(flushed)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceparent(t *testing.T) {
+	h := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(h)
+	if !ok {
+		t.Fatalf("Traceparent should be valid: %s", h)
+	}
+	if !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("Span context does not match. Got: %+v", sc)
+	}
+	if sc.Traceparent() != h {
+		t.Errorf("Traceparent does not match. Got: %s, Expected: %s", sc.Traceparent(), h)
+	}
+
+	for _, h := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(h); ok {
+			t.Errorf("Traceparent should be invalid: %s", h)
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	tr := &Tracer{sampleRatio: 1, spans: make(chan *Span, 10)}
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s := tr.StartAt("receive", KindServer, parent, time.Unix(0, 1))
+	if s.Context.TraceID != parent.TraceID || s.Parent != parent.SpanID || s.Context.SpanID == parent.SpanID {
+		t.Errorf("Span should be a child of the parent. Got: %+v", s)
+	}
+	s.SetAttribute("customer", "servicex")
+	s.SetError("incoming queue full")
+	s.FinishAt(time.Unix(0, 2))
+
+	// The sampling decision of the parent is honored.
+	notSampled := parent
+	notSampled.Sampled = false
+	never := &Tracer{sampleRatio: 0, spans: make(chan *Span, 10)}
+	ns := never.StartAt("receive", KindServer, notSampled, time.Now())
+	if ns.SpanContext() != notSampled {
+		t.Errorf("Span of a trace that is not sampled should propagate the parent. Got: %+v, Expected: %+v", ns.SpanContext(), notSampled)
+	}
+	ns.SetAttribute("customer", "servicex")
+	ns.Finish()
+	if len(never.spans) != 0 {
+		t.Errorf("Span of a trace that is not sampled should not be exported")
+	}
+	if s := tr.StartAt("receive", KindServer, notSampled, time.Now()); !s.SpanContext().Sampled || s.Parent != notSampled.SpanID {
+		t.Errorf("Sample ratio should apply to a parent that is not sampled. Got: %+v", s)
+	}
+	var disabled *Tracer
+	if s := disabled.StartAt("receive", KindServer, notSampled, time.Now()); s.SpanContext().Traceparent() != notSampled.Traceparent() {
+		t.Errorf("Disabled tracer should propagate the parent. Got: %s", s.SpanContext().Traceparent())
+	}
+	var nilSpan *Span
+	nilSpan.SetAttribute("customer", "servicex")
+	nilSpan.Finish()
+
+	var b bytes.Buffer
+	w := &Writer{W: &b}
+	w.Export([]*Span{<-tr.spans})
+	out := b.String()
+	for _, exp := range []string{
+		`"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`,
+		`"parentSpanId":"00f067aa0ba902b7"`,
+		`"startTimeUnixNano":"1"`,
+		`"attributes":[{"key":"customer","value":{"stringValue":"servicex"}}]`,
+		`"status":{"code":2,"message":"incoming queue full"}`,
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("Exported span does not contain %s. Got: %s", exp, out)
+		}
+	}
+}
+
+func TestTracerFlush(t *testing.T) {
+	var b bytes.Buffer
+	tr := NewTracer(&Writer{W: &b}, 1)
+	tr.StartAt("receive", KindServer, SpanContext{}, time.Now()).Finish()
+	tr.Flush(time.Second)
+	if !strings.Contains(b.String(), `"name":"receive"`) {
+		t.Errorf("Flush should export the finished spans. Got: %s", b.String())
+	}
+	var nilTracer *Tracer
+	nilTracer.Flush(time.Second)
+}
@@ -0,0 +1,333 @@
+// Package usage keeps track of what the customers write.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package usage
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Counts are the volumes of a customer over an hour.
+type Counts struct {
+	Batches           int64 `json:"batches"`
+	Points            int64 `json:"points"`
+	Bytes             int64 `json:"bytes"` // compressed
+	UncompressedBytes int64 `json:"uncompressed_bytes"`
+	RejectedWrites    int64 `json:"rejected_writes"`
+	Queries           int64 `json:"queries"`
+}
+
+func (c *Counts) add(o Counts) {
+	c.Batches += o.Batches
+	c.Points += o.Points
+	c.Bytes += o.Bytes
+	c.UncompressedBytes += o.UncompressedBytes
+	c.RejectedWrites += o.RejectedWrites
+	c.Queries += o.Queries
+}
+
+// Record is the usage of a customer over an hour.
+type Record struct {
+	Hour     time.Time `json:"hour"`
+	Customer string    `json:"customer"`
+	Email    string    `json:"email"`
+	Counts
+}
+
+// Store keeps hourly usage records per customer. Records of past hours are appended to a file once
+// the hour is over, the records of the current hour are saved next to it every save interval, so
+// that the usage survives restarts. The files are only written by Save, Add does no I/O.
+// A nil *Store records nothing.
+type Store struct {
+	sync.Mutex
+	saving    sync.Mutex // held by Save while it writes the files, without the lock
+	path      string
+	retention time.Duration
+	closed    []Record           // records of the past hours, oldest first
+	unsaved   []Record           // records of the past hours not appended to the file yet
+	expired   bool               // records were dropped by the retention, the file has to be rewritten
+	current   map[string]*Record // records of the current hour by customer
+	hour      time.Time
+	now       func() time.Time
+}
+
+// NewStore loads the records saved in path and drops the ones older than retention, 0 keeps all.
+func NewStore(path string, retention time.Duration) (*Store, error) {
+	return newStore(path, retention, time.Now)
+}
+
+func newStore(path string, retention time.Duration, now func() time.Time) (*Store, error) {
+	s := &Store{path: path, retention: retention, current: make(map[string]*Record), now: now}
+	s.hour = s.now().UTC().Truncate(time.Hour)
+
+	closed, err := readRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	current, err := readRecords(s.currentPath())
+	if err != nil {
+		return nil, err
+	}
+	// The records of the current hour may also be in the file if the process stopped while closing the hour.
+	type key struct {
+		hour     time.Time
+		customer string
+	}
+	saved := make(map[key]bool)
+	for _, r := range closed {
+		saved[key{r.Hour.UTC(), r.Customer}] = true
+	}
+	for i := range current {
+		r := current[i]
+		switch {
+		case saved[key{r.Hour.UTC(), r.Customer}]:
+		case r.Hour.Equal(s.hour):
+			s.current[r.Customer] = &r
+		default:
+			closed = append(closed, r)
+		}
+	}
+	s.closed = closed
+	s.expire()
+	if err := writeFile(s.path, s.closed); err != nil {
+		return nil, err
+	}
+	return s, writeFile(s.currentPath(), s.currentRecords())
+}
+
+func (s *Store) currentPath() string {
+	return s.path + ".current"
+}
+
+// readRecords reads json records, one per line. A missing file has no records.
+func readRecords(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			// A partially written last line after a crash.
+			log.Errorf("Skipping invalid usage record in %s: %v", path, err)
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, sc.Err()
+}
+
+// writeFile writes the records to a temporary file and renames it to path.
+func writeFile(path string, records []Record) error {
+	var buf []byte
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	if err := ioutil.WriteFile(path+".tmp", buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// expire drops the records older than the retention. It returns true if records were dropped.
+func (s *Store) expire() bool {
+	if s.retention <= 0 {
+		return false
+	}
+	sort.SliceStable(s.closed, func(i, j int) bool { return s.closed[i].Hour.Before(s.closed[j].Hour) })
+	oldest := s.hour.Add(-s.retention)
+	i := sort.Search(len(s.closed), func(i int) bool { return !s.closed[i].Hour.Before(oldest) })
+	s.closed = s.closed[i:]
+	return i > 0
+}
+
+func (s *Store) currentRecords() []Record {
+	records := make([]Record, 0, len(s.current))
+	for _, r := range s.current {
+		records = append(records, *r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Customer < records[j].Customer })
+	return records
+}
+
+// rollover closes the current hour if it is over and drops the records older than the retention. The
+// records of the closed hour are saved by the next Save. It must be called with the lock held.
+func (s *Store) rollover() {
+	hour := s.now().UTC().Truncate(time.Hour)
+	if !hour.After(s.hour) {
+		return
+	}
+	records := s.currentRecords()
+	s.closed = append(s.closed, records...)
+	s.unsaved = append(s.unsaved, records...)
+	s.current = make(map[string]*Record)
+	s.hour = hour
+	if s.expire() {
+		s.expired = true
+	}
+}
+
+// appendRecords appends json records to a file, one per line.
+func appendRecords(path string, records []Record) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add adds counts to the record of the current hour of a customer.
+func (s *Store) Add(customer string, email string, c Counts) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.rollover()
+	r, ok := s.current[customer]
+	if !ok {
+		r = &Record{Hour: s.hour, Customer: customer}
+		s.current[customer] = r
+	}
+	r.Email = email
+	r.Counts.add(c)
+}
+
+// Save closes the current hour if it is over, appends the records of the closed hours to the file, or rewrites
+// it if records were dropped by the retention, and saves the records of the current hour. The files are
+// written from a copy of the records, Add is not held up.
+func (s *Store) Save() error {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+
+	s.Lock()
+	s.rollover()
+	expired, unsaved, current := s.expired, s.unsaved, s.currentRecords()
+	var closed []Record
+	if expired {
+		closed = append(closed, s.closed...)
+	}
+	s.expired, s.unsaved = false, nil
+	s.Unlock()
+
+	var err error
+	if expired {
+		err = writeFile(s.path, closed)
+	} else if len(unsaved) > 0 {
+		err = appendRecords(s.path, unsaved)
+	}
+	if err != nil {
+		// Saved again by the next Save.
+		s.Lock()
+		if expired {
+			s.expired = true
+		} else {
+			s.unsaved = append(unsaved, s.unsaved...)
+		}
+		s.Unlock()
+		return err
+	}
+	return writeFile(s.currentPath(), current)
+}
+
+// Run saves the store every interval. The hours are closed and the retention is enforced by the saves.
+func (s *Store) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.Save(); err != nil {
+			log.Errorf("Error saving usage records: %v", err)
+		}
+	}
+}
+
+// Records returns the records of the hours in [from, to) of a customer, or of all the customers
+// if customer is empty, ordered by hour and customer.
+func (s *Store) Records(from time.Time, to time.Time, customer string) []Record {
+	s.Lock()
+	all := append(append([]Record(nil), s.closed...), s.currentRecords()...)
+	s.Unlock()
+
+	var records []Record
+	for _, r := range all {
+		if r.Hour.Before(from) || !r.Hour.Before(to) || (customer != "" && r.Customer != customer) {
+			continue
+		}
+		records = append(records, r)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].Hour.Equal(records[j].Hour) {
+			return records[i].Hour.Before(records[j].Hour)
+		}
+		return records[i].Customer < records[j].Customer
+	})
+	return records
+}
+
+// WriteCSV writes the records as csv with a header line.
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"hour", "customer", "email", "batches", "points", "bytes", "uncompressed_bytes", "rejected_writes", "queries"})
+	for _, r := range records {
+		cw.Write([]string{
+			r.Hour.Format(time.RFC3339),
+			r.Customer,
+			r.Email,
+			strconv.FormatInt(r.Batches, 10),
+			strconv.FormatInt(r.Points, 10),
+			strconv.FormatInt(r.Bytes, 10),
+			strconv.FormatInt(r.UncompressedBytes, 10),
+			strconv.FormatInt(r.RejectedWrites, 10),
+			strconv.FormatInt(r.Queries, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
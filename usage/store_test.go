@@ -0,0 +1,134 @@
+package usage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "usage.jsonl")
+
+	now := time.Date(2017, 6, 1, 10, 30, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	s, err := newStore(path, 0, clock)
+	if err != nil {
+		t.Fatalf("Error creating the store: %v", err)
+	}
+
+	s.Add("servicex", "user1@email.com", Counts{Batches: 1, Points: 10})
+	s.Add("servicex", "user1@email.com", Counts{Batches: 1, Points: 5})
+	s.Add("servicey", "user2@email.com", Counts{Queries: 1})
+	now = now.Add(time.Hour)
+	s.Add("servicex", "user1@email.com", Counts{RejectedWrites: 1})
+	if err := s.Save(); err != nil {
+		t.Fatalf("Error saving the store: %v", err)
+	}
+
+	// A restart in the same hour keeps counting the current hour.
+	s, err = newStore(path, 0, clock)
+	if err != nil {
+		t.Fatalf("Error loading the store: %v", err)
+	}
+	s.Add("servicex", "user1@email.com", Counts{RejectedWrites: 1})
+
+	records := s.Records(time.Time{}, now.Add(time.Hour), "")
+	if len(records) != 3 {
+		t.Fatalf("Number of records does not match. Got: %d, Expected: 3", len(records))
+	}
+	if r := records[0]; r.Customer != "servicex" || r.Batches != 2 || r.Points != 15 || r.Email != "user1@email.com" {
+		t.Errorf("First record does not match. Got: %+v", r)
+	}
+	if r := records[2]; r.Customer != "servicex" || r.RejectedWrites != 2 {
+		t.Errorf("Current hour record does not match. Got: %+v", r)
+	}
+	if records := s.Records(now.Truncate(time.Hour), now.Add(time.Hour), "servicey"); len(records) != 0 {
+		t.Errorf("Records should be filtered by hour and customer. Got: %v", records)
+	}
+
+	var b bytes.Buffer
+	WriteCSV(&b, records[1:2])
+	exp := "hour,customer,email,batches,points,bytes,uncompressed_bytes,rejected_writes,queries\n" +
+		"2017-06-01T10:00:00Z,servicey,user2@email.com,0,0,0,0,0,1\n"
+	if b.String() != exp {
+		t.Errorf("CSV does not match. Got: %s, Expected: %s", b.String(), exp)
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "usage.jsonl")
+
+	now := time.Date(2017, 6, 1, 10, 30, 0, 0, time.UTC)
+	writeFile(path, []Record{
+		{Hour: now.Add(-48 * time.Hour).Truncate(time.Hour), Customer: "servicex"},
+		{Hour: now.Add(-2 * time.Hour).Truncate(time.Hour), Customer: "servicex"},
+	})
+	s, err := newStore(path, 24*time.Hour, func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("Error loading the store: %v", err)
+	}
+	if records := s.Records(time.Time{}, now, ""); len(records) != 1 {
+		t.Errorf("Records older than the retention should be dropped. Got: %v", records)
+	}
+}
+
+func TestStoreRollover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "usage.jsonl")
+
+	now := time.Date(2017, 6, 1, 10, 30, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	s, err := newStore(path, 24*time.Hour, clock)
+	if err != nil {
+		t.Fatalf("Error creating the store: %v", err)
+	}
+	s.Add("servicex", "user1@email.com", Counts{Batches: 1})
+	s.Save()
+	current, _ := ioutil.ReadFile(s.currentPath())
+
+	// A crash right after the hour was closed, with the closed hour still saved as the current one.
+	now = now.Add(time.Hour)
+	s.Add("servicey", "user2@email.com", Counts{Batches: 1})
+	if records, _ := readRecords(path); len(records) != 0 {
+		t.Errorf("Add should not write the file. Got: %v", records)
+	}
+	s.Save()
+	ioutil.WriteFile(s.currentPath(), current, 0644)
+	s, err = newStore(path, 24*time.Hour, clock)
+	if err != nil {
+		t.Fatalf("Error loading the store: %v", err)
+	}
+	if records := s.Records(time.Time{}, now.Add(time.Hour), ""); len(records) != 1 || records[0].Batches != 1 {
+		t.Errorf("A closed hour should be loaded once. Got: %v", records)
+	}
+
+	// The retention is enforced when an hour is closed, the file is rewritten by the next save.
+	now = now.Add(25 * time.Hour)
+	s.Add("servicex", "user1@email.com", Counts{Batches: 1})
+	if records := s.Records(time.Time{}, now.Truncate(time.Hour), ""); len(records) != 0 {
+		t.Errorf("Records older than the retention should be dropped. Got: %v", records)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Error saving the store: %v", err)
+	}
+	if records, _ := readRecords(path); len(records) != 0 {
+		t.Errorf("Records older than the retention should be dropped from the file. Got: %v", records)
+	}
+}
@@ -0,0 +1,255 @@
+// Package usage keeps track of what the customers write.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/samitpal/influxdb-router/logging"
+)
+
+var log = logging.For("usage")
+
+// DefaultMaxBatchBytes is the default max uncompressed size of a batch.
+const DefaultMaxBatchBytes = 64 * 1024 * 1024
+
+// ErrTooLarge is returned by Parse for a batch over the max uncompressed size.
+var ErrTooLarge = errors.New("batch too large")
+
+// DefaultMaxMeasurements is the number of measurements tracked by name per customer.
+const DefaultMaxMeasurements = 1000
+
+// OtherMeasurements is the name the measurements over the tracking limit are counted under.
+const OtherMeasurements = "_other"
+
+// Totals are the volumes written by a customer.
+type Totals struct {
+	Batches           int64 `json:"batches"`
+	Lines             int64 `json:"lines"` // non empty lines, comments included
+	Points            int64 `json:"points"`
+	Fields            int64 `json:"fields"`
+	Bytes             int64 `json:"bytes"` // compressed
+	UncompressedBytes int64 `json:"uncompressed_bytes"`
+}
+
+func (t *Totals) add(o Totals) {
+	t.Batches += o.Batches
+	t.Lines += o.Lines
+	t.Points += o.Points
+	t.Fields += o.Fields
+	t.Bytes += o.Bytes
+	t.UncompressedBytes += o.UncompressedBytes
+}
+
+// Measurement is the volume written to a measurement.
+type Measurement struct {
+	Name   string `json:"name"`
+	Points int64  `json:"points"`
+	Bytes  int64  `json:"bytes"` // uncompressed
+}
+
+// Batch is what a batch of line protocol contains.
+type Batch struct {
+	Totals
+	Measurements map[string]*Measurement
+}
+
+// Parse decompresses a gzip compressed batch of line protocol and counts its lines, points,
+// fields and the points and bytes per measurement. At most maxBytes (DefaultMaxBatchBytes if 0) are
+// decompressed, ErrTooLarge is returned for a larger batch. A line can be as long as the batch.
+func Parse(compressed []byte, maxBytes int64) (*Batch, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBatchBytes
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	b := &Batch{Totals: Totals{Batches: 1, Bytes: int64(len(compressed))}, Measurements: make(map[string]*Measurement)}
+	cr := &countingReader{r: io.LimitReader(zr, maxBytes+1)}
+	s := bufio.NewScanner(cr)
+	s.Buffer(make([]byte, 64*1024), int(maxBytes+1))
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		b.Lines++
+		if line[0] == '#' {
+			continue
+		}
+		name, fields := parseLine(line)
+		b.Points++
+		b.Fields += int64(fields)
+		m, ok := b.Measurements[string(name)]
+		if !ok {
+			m = &Measurement{Name: string(name)}
+			b.Measurements[m.Name] = m
+		}
+		m.Points++
+		m.Bytes += int64(len(line)) + 1
+	}
+	if cr.n > maxBytes {
+		return nil, ErrTooLarge
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	b.UncompressedBytes = cr.n
+	return b, nil
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// MeasurementName returns the measurement of a line of line protocol, as escaped in the line.
+func MeasurementName(line []byte) []byte {
+	name, _ := parseLine(line)
+	return name
+}
+
+// parseLine returns the measurement and the number of fields of a line of line protocol.
+// A line is: measurement[,tag=value...] field=value[,field=value...] [timestamp]
+func parseLine(line []byte) ([]byte, int) {
+	i := 0
+	// measurement
+	for i < len(line) && line[i] != ',' && line[i] != ' ' {
+		if line[i] == '\\' {
+			i++
+		}
+		i++
+	}
+	if i > len(line) {
+		i = len(line)
+	}
+	name := line[:i]
+	// tags
+	for i < len(line) && line[i] != ' ' {
+		if line[i] == '\\' {
+			i++
+		}
+		i++
+	}
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+	if i >= len(line) {
+		return name, 0
+	}
+	// fields
+	fields := 1
+	quoted := false
+	for ; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			fields++
+		case c == ' ' && !quoted:
+			return name, fields
+		}
+	}
+	return name, fields
+}
+
+// Tracker accumulates the batches of a customer. Only the first maxMeasurements measurements are
+// tracked by name, the others are counted under OtherMeasurements.
+type Tracker struct {
+	sync.Mutex
+	totals          Totals
+	measurements    map[string]*Measurement
+	maxMeasurements int
+}
+
+// NewTracker returns an empty *Tracker.
+func NewTracker(maxMeasurements int) *Tracker {
+	return &Tracker{measurements: make(map[string]*Measurement), maxMeasurements: maxMeasurements}
+}
+
+// Add adds a batch.
+func (t *Tracker) Add(b *Batch) {
+	t.Lock()
+	defer t.Unlock()
+	t.totals.add(b.Totals)
+	for name, bm := range b.Measurements {
+		m, ok := t.measurements[name]
+		if !ok {
+			if len(t.measurements) >= t.maxMeasurements {
+				name = OtherMeasurements
+			}
+			if m, ok = t.measurements[name]; !ok {
+				m = &Measurement{Name: name}
+				t.measurements[name] = m
+			}
+		}
+		m.Points += bm.Points
+		m.Bytes += bm.Bytes
+	}
+}
+
+// Totals returns the totals of the customer.
+func (t *Tracker) Totals() Totals {
+	t.Lock()
+	defer t.Unlock()
+	return t.totals
+}
+
+// Top returns the n measurements with the most points.
+func (t *Tracker) Top(n int) []Measurement {
+	t.Lock()
+	top := make([]Measurement, 0, len(t.measurements))
+	for _, m := range t.measurements {
+		top = append(top, *m)
+	}
+	t.Unlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Points != top[j].Points {
+			return top[i].Points > top[j].Points
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
@@ -0,0 +1,85 @@
+package usage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func compress(s string) []byte {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	raw := "cpu,host=a usage_idle=99,usage_user=1 1\n\n# comment\n  mem,host=a used=1 1\r\ncpu,host=b usage_idle=98 1"
+	b, err := Parse(compress(raw), 0)
+	if err != nil {
+		t.Fatalf("Error parsing batch: %v", err)
+	}
+	if b.Lines != 4 || b.Points != 3 || b.Fields != 4 {
+		t.Errorf("Counts do not match. Got: %+v, Expected: 4 lines, 3 points, 4 fields", b.Totals)
+	}
+	if b.UncompressedBytes != int64(len(raw)) {
+		t.Errorf("Uncompressed bytes do not match. Got: %d, Expected: %d", b.UncompressedBytes, len(raw))
+	}
+	if b.Measurements["cpu"] == nil || b.Measurements["cpu"].Points != 2 || b.Measurements["mem"].Points != 1 {
+		t.Errorf("Measurements do not match. Got: %v", b.Measurements)
+	}
+
+	if _, err := Parse([]byte("not gzip"), 0); err == nil {
+		t.Error("Parsing an uncompressed batch should fail")
+	}
+
+	// The max is enforced on the uncompressed size, a single line can be as long as the batch.
+	if _, err := Parse(compress(strings.Repeat("a", 1024)), 1023); err != ErrTooLarge {
+		t.Errorf("Parsing a batch over the max should fail. Got: %v, Expected: %v", err, ErrTooLarge)
+	}
+	long := "log msg=\"" + strings.Repeat("a", 17*1024*1024) + "\""
+	if b, err := Parse(compress(long), 0); err != nil || b.Points != 1 {
+		t.Errorf("A long line should be parsed. Got: %v", err)
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		name   string
+		fields int
+	}{
+		{`cpu value=1`, "cpu", 1},
+		{`cpu\ load,host=a\ b value=1,other=2i 1500000000`, `cpu\ load`, 2},
+		{`log,host=a msg="a, b c",level="info" 1`, "log", 2},
+		{`weird\,name a=1`, `weird\,name`, 1},
+		{`nofields`, "nofields", 0},
+	}
+	for _, tt := range tests {
+		name, fields := parseLine([]byte(tt.line))
+		if string(name) != tt.name || fields != tt.fields {
+			t.Errorf("Parsed line does not match for %s. Got: %s %d, Expected: %s %d", tt.line, name, fields, tt.name, tt.fields)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker(2)
+	for _, raw := range []string{"a v=1\na v=1\nb v=1", "c v=1\nb v=1\nb v=1"} {
+		b, _ := Parse(compress(raw), 0)
+		tr.Add(b)
+	}
+	if tot := tr.Totals(); tot.Batches != 2 || tot.Points != 6 {
+		t.Errorf("Totals do not match. Got: %+v", tot)
+	}
+	top := tr.Top(2)
+	if len(top) != 2 || top[0].Name != "b" || top[0].Points != 3 || top[1].Name != "a" {
+		t.Errorf("Top measurements do not match. Got: %v", top)
+	}
+	all := tr.Top(10)
+	if len(all) != 3 || all[2].Name != OtherMeasurements || all[2].Points != 1 {
+		t.Errorf("Measurements over the limit should be counted as %s. Got: %v", OtherMeasurements, all)
+	}
+}
@@ -28,12 +28,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
 	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/samitpal/influxdb-router/logging"
 )
 
@@ -159,6 +161,41 @@ func (r *Response) Error() error {
 	return nil
 }
 
+// Results of a write.
+const (
+	ResultSuccess         = "success"
+	ResultPartial         = "partial"      // some points were dropped by the backend, e.g. field type conflicts
+	ResultClientError     = "client_error" // 4xx, e.g. database not found or unable to parse
+	ResultServerError     = "server_error" // 5xx
+	ResultTimeout         = "timeout"
+	ResultConnectionError = "connection_error"
+)
+
+// Writer writes batches to an InfluxDB backend.
+type Writer interface {
+	WriteInflux(r io.Reader, db string, id string, url string, traceparent string) (string, error)
+}
+
+// result returns the result class of a write from the status code and the error of the request.
+func result(code int, err error) string {
+	switch {
+	case err == nil:
+		return ResultSuccess
+	case code == 0:
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return ResultTimeout
+		}
+		return ResultConnectionError
+	case code >= 500:
+		return ResultServerError
+	case strings.Contains(err.Error(), "partial write"), strings.Contains(err.Error(), "field type conflict"),
+		strings.Contains(err.Error(), "points beyond retention policy"):
+		return ResultPartial
+	default:
+		return ResultClientError
+	}
+}
+
 type httpClient struct {
 	writeURL string
 	config   HTTPConfig
@@ -166,67 +203,77 @@ type httpClient struct {
 	url      *url.URL
 }
 
-func (c *httpClient) WriteInflux(r io.Reader, db string, id string, url string) {
-	if e := c.WriteStream(r); e != nil {
+// WriteInflux writes a batch and returns the result class and the error of the write.
+// The traceparent header is sent unless it is empty.
+func (c *httpClient) WriteInflux(r io.Reader, db string, id string, url string, traceparent string) (string, error) {
+	code, e := c.WriteStream(r, traceparent)
+	res := result(code, e)
+	l := log.WithFields(logrus.Fields{"message_id": id, "db": db, "backend": url, "status": code})
+	if e != nil {
 		// If the database was not found
 		if strings.Contains(e.Error(), "database not found") {
-			log.Errorf("E! Error: Database %s not found\n", db)
-			return
+			l.Errorf("E! Error: Database %s not found\n", db)
+			return res, e
 		}
 
 		if strings.Contains(e.Error(), "field type conflict") {
-			log.Errorf("E! Field type conflict, dropping conflicted points: %s", e)
-			return
+			l.Errorf("E! Field type conflict, dropping conflicted points: %s", e)
+			return res, e
 		}
 
 		if strings.Contains(e.Error(), "points beyond retention policy") {
-			log.Errorf("W! Points beyond retention policy: %s", e)
-			return
+			l.Errorf("W! Points beyond retention policy: %s", e)
+			return res, e
 		}
 
 		if strings.Contains(e.Error(), "unable to parse") {
-			log.Errorf("E! Parse error; dropping points: %s", e)
-			return
+			l.Errorf("E! Parse error; dropping points: %s", e)
+			return res, e
 		}
 
 		if strings.Contains(e.Error(), "hinted handoff queue not empty") {
-			return
+			return res, e
 		}
 
 		// Log any other write failure
-		log.Errorf("E! InfluxDB Output Error: %v", e)
-		return
+		l.Errorf("E! InfluxDB Output Error: %v", e)
+		return res, e
 	}
-	log.Infof("Successfully sent message-id: %s, db: %s, backend: %s", id, db, url)
+	l.Debug("Successfully sent batch")
+	return res, nil
 }
 
-func (c *httpClient) WriteStream(r io.Reader) error {
+// WriteStream writes a batch and returns the status code of the response, 0 if there was none.
+func (c *httpClient) WriteStream(r io.Reader, traceparent string) (int, error) {
 	req, err := c.makeWriteRequest(r, c.writeURL)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if traceparent != "" {
+		req.Header.Set("traceparent", traceparent)
 	}
 	return c.doRequest(req, http.StatusNoContent)
 }
 
-func (c *httpClient) doRequest(req *http.Request, expectedCode int) error {
+func (c *httpClient) doRequest(req *http.Request, expectedCode int) (int, error) {
 	resp, err := c.client.Do(req)
 	if err != nil {
 		log.Info("http req failed.")
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	code := resp.StatusCode
 	// If it's a "no content" response, then release and return nil
 	if code == http.StatusNoContent {
-		return nil
+		return code, nil
 	}
 
 	// not a "no content" response, so parse the result:
 	var response Response
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("Fatal error reading body: %s", err)
+		return code, fmt.Errorf("Fatal error reading body: %s", err)
 	}
 
 	decErr := json.Unmarshal(body, &response)
@@ -242,7 +289,7 @@ func (c *httpClient) doRequest(req *http.Request, expectedCode int) error {
 			code, expectedCode, response.Error())
 	}
 
-	return err
+	return code, err
 }
 
 func (c *httpClient) makeWriteRequest(
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"errors"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		code int
+		err  error
+		exp  string
+	}{
+		{204, nil, ResultSuccess},
+		{400, errors.New("Response Error: Status Code [400], expected [204], [partial write: field type conflict]"), ResultPartial},
+		{404, errors.New("Response Error: Status Code [404], expected [204], [database not found]"), ResultClientError},
+		{503, errors.New("Response Error: Status Code [503], expected [204], []"), ResultServerError},
+		{0, timeoutError{}, ResultTimeout},
+		{0, errors.New("connection refused"), ResultConnectionError},
+	}
+	for _, tt := range tests {
+		if r := result(tt.code, tt.err); r != tt.exp {
+			t.Errorf("Result does not match for %d %v. Got: %s, Expected: %s", tt.code, tt.err, r, tt.exp)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package writer provides code for wiring metrics to influxdb
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package writer
+
+import (
+	"sort"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+// defaultQuantum is the number of bytes a customer of weight 1 may dispatch per round.
+const defaultQuantum = 64 * 1024
+
+// flow is the dispatcher state of a customer.
+type flow struct {
+	apiKey  string
+	conf    config.APIKeyConfig
+	head    *backends.Payload // batch taken off the incoming queue but not dispatched yet
+	deficit int
+}
+
+// full tells whether the next batch of the customer does not fit in one of its out going queues. Its
+// batches are then held back in its incoming queue rather than dropped by distribute.
+func (f *flow) full() bool {
+	if f.head == nil {
+		return false
+	}
+	for _, d := range f.conf.Dests {
+		if len(d.Queue) >= cap(d.Queue) || !d.QueueBytes.Fits(len(f.head.Body)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatcher moves batches from the incoming queues of the customers to the out going queues
+// using deficit round robin. Customers of a higher priority are always served first, customers
+// of the same priority share the dispatcher in proportion to their weight, in bytes.
+type dispatcher struct {
+	ingress *backends.Ingress
+	flows   []*flow
+	quantum int
+	blocked bool // the last round held back customers whose out going queues are full
+}
+
+func newDispatcher(apiConf config.APIKeyMap, ingress *backends.Ingress, quantum int) *dispatcher {
+	d := &dispatcher{ingress: ingress, quantum: quantum}
+	for k, c := range apiConf {
+		d.flows = append(d.flows, &flow{apiKey: k, conf: c})
+	}
+	sort.Slice(d.flows, func(i, j int) bool {
+		if d.flows[i].conf.IncomingQueue.Priority != d.flows[j].conf.IncomingQueue.Priority {
+			return d.flows[i].conf.IncomingQueue.Priority > d.flows[j].conf.IncomingQueue.Priority
+		}
+		return d.flows[i].conf.Name < d.flows[j].conf.Name
+	})
+	return d
+}
+
+// pop takes the next batch of a customer off its incoming queue. The batch is held by the ingress till it is
+// dispatched, so that Drain waits for it and Spill writes it.
+func (d *dispatcher) pop(f *flow) {
+	f.head = d.ingress.Pop(f.conf.IncomingQueue)
+	if f.head != nil {
+		d.ingress.Hold(f.conf.IncomingQueue, f.head)
+	}
+}
+
+// round serves the backlogged customers of the highest priority once and passes
+// every dispatched batch to out. The customers whose out going queues are full are skipped.
+// It returns false if there is nothing left that can be dispatched.
+func (d *dispatcher) round(out func(*backends.Payload, config.APIKeyConfig)) bool {
+	priority, backlog := 0, false
+	d.blocked = false
+	for _, f := range d.flows {
+		// A held batch taken by a purge is dropped by it.
+		if f.head != nil && !d.ingress.Holds(f.head) {
+			f.head = nil
+		}
+		if f.head == nil {
+			d.pop(f)
+		}
+		if f.head != nil && f.full() {
+			d.blocked = true
+			continue
+		}
+		if f.head != nil && (!backlog || f.conf.IncomingQueue.Priority > priority) {
+			priority, backlog = f.conf.IncomingQueue.Priority, true
+		}
+	}
+	if !backlog {
+		return false
+	}
+
+	for _, f := range d.flows {
+		if f.head == nil || f.conf.IncomingQueue.Priority != priority || f.full() {
+			continue
+		}
+		f.deficit += d.quantum * f.conf.IncomingQueue.Weight
+		for f.head != nil && len(f.head.Body) <= f.deficit && !f.full() {
+			f.deficit -= len(f.head.Body)
+			if d.ingress.Unhold(f.head) {
+				out(f.head, f.conf)
+			}
+			d.pop(f)
+		}
+		// An idle customer does not build up credit.
+		if f.head == nil {
+			f.deficit = 0
+		}
+	}
+	return true
+}
+
+// update follows the changes of the customers. The state of the customers whose incoming queue did not
+// change is kept. The batches of a replaced incoming queue are moved to the new incoming queue of the
+// customer, found with config.APIKeyMap.Successor, those of a removed customer are dropped.
+func (d *dispatcher) update(apiConf config.APIKeyMap) {
+	flows := make(map[*backends.IncomingQueue]*flow)
+	for _, f := range d.flows {
+		flows[f.conf.IncomingQueue] = f
+	}
+	next := newDispatcher(apiConf, d.ingress, d.quantum)
+	for _, f := range next.flows {
+		if o, ok := flows[f.conf.IncomingQueue]; ok {
+			f.head, f.deficit = o.head, o.deficit
+			delete(flows, f.conf.IncomingQueue)
+		}
+	}
+	for q, o := range flows {
+		pending := []*backends.Payload{}
+		if o.head != nil && d.ingress.Unhold(o.head) {
+			pending = append(pending, o.head)
+		}
+		for p := d.ingress.Pop(q); p != nil; p = d.ingress.Pop(q) {
+			pending = append(pending, p)
+		}
+		c, ok := apiConf.Successor(o.apiKey, o.conf.Name)
+		for _, p := range pending {
+			if !ok {
+				DropBatch(p, o.conf.Name, "", "customer_removed")
+			} else if !d.ingress.Push(c.IncomingQueue, p) {
+				DropBatch(p, o.conf.Name, "", "incoming_queue_full")
+			}
+		}
+	}
+	d.flows = next.flows
+}
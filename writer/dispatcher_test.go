@@ -0,0 +1,159 @@
+package writer
+
+import (
+	"testing"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+func newTestConf(name string, weight int, priority int) config.APIKeyConfig {
+	return config.APIKeyConfig{Name: name, IncomingQueue: backends.NewIncomingQueue(100, weight, priority)}
+}
+
+func fill(ingress *backends.Ingress, c config.APIKeyConfig, n int, size int) {
+	for i := 0; i < n; i++ {
+		ingress.Push(c.IncomingQueue, &backends.Payload{APIKey: c.Name, Body: make([]byte, size)})
+	}
+}
+
+func TestDispatcherWeights(t *testing.T) {
+	ingress := backends.NewIngress(1000)
+	a := newTestConf("a", 1, 0)
+	b := newTestConf("b", 3, 0)
+	fill(ingress, a, 50, 10)
+	fill(ingress, b, 50, 10)
+
+	d := newDispatcher(config.APIKeyMap{"a": a, "b": b}, ingress, 10)
+	got := map[string]int{}
+	for i := 0; i < 10; i++ {
+		d.round(func(m *backends.Payload, c config.APIKeyConfig) { got[c.Name]++ })
+	}
+	if got["a"] != 10 || got["b"] != 30 {
+		t.Errorf("Dispatched batches do not follow the weights. Got: a=%d b=%d, Expected: a=10 b=30", got["a"], got["b"])
+	}
+}
+
+func TestDispatcherPriority(t *testing.T) {
+	ingress := backends.NewIngress(1000)
+	low := newTestConf("low", 1, 0)
+	high := newTestConf("high", 1, 1)
+	fill(ingress, low, 5, 10)
+	fill(ingress, high, 5, 10)
+
+	d := newDispatcher(config.APIKeyMap{"low": low, "high": high}, ingress, 10)
+	var order []string
+	for d.round(func(m *backends.Payload, c config.APIKeyConfig) { order = append(order, c.Name) }) {
+	}
+	if len(order) != 10 {
+		t.Fatalf("All batches should be dispatched. Got: %d, Expected: 10", len(order))
+	}
+	for i, n := range order {
+		if (i < 5 && n != "high") || (i >= 5 && n != "low") {
+			t.Errorf("High priority batches should be dispatched first. Got: %v", order)
+			break
+		}
+	}
+	if ingress.Len() != 0 {
+		t.Errorf("Ingress should be empty. Got: %d", ingress.Len())
+	}
+}
+
+func TestDispatcherLargeBatch(t *testing.T) {
+	ingress := backends.NewIngress(1000)
+	a := newTestConf("a", 1, 0)
+	fill(ingress, a, 1, 35)
+
+	d := newDispatcher(config.APIKeyMap{"a": a}, ingress, 10)
+	rounds, dispatched := 0, 0
+	for d.round(func(m *backends.Payload, c config.APIKeyConfig) { dispatched++ }) {
+		rounds++
+	}
+	if dispatched != 1 || rounds != 4 {
+		t.Errorf("Batch larger than the quantum should be dispatched after enough rounds. Got: %d batches in %d rounds", dispatched, rounds)
+	}
+}
+
+func TestDispatcherUpdate(t *testing.T) {
+	ingress := backends.NewIngress(1000)
+	a := newTestConf("a", 1, 0)
+	b := newTestConf("b", 1, 0)
+	fill(ingress, a, 5, 10)
+	fill(ingress, b, 5, 10)
+	d := newDispatcher(config.APIKeyMap{"a": a, "b": b}, ingress, 10)
+
+	// a is updated with a new incoming queue and b is removed.
+	a2 := newTestConf("a", 1, 0)
+	d.update(config.APIKeyMap{"a": a2})
+
+	got := map[*backends.IncomingQueue]int{}
+	for d.round(func(m *backends.Payload, c config.APIKeyConfig) { got[c.IncomingQueue]++ }) {
+	}
+	if got[a2.IncomingQueue] != 5 || len(got) != 1 {
+		t.Errorf("Batches of a should move to the new queue. Got: %v", got)
+	}
+	if ingress.Len() != 0 {
+		t.Errorf("Ingress should be empty. Got: %d", ingress.Len())
+	}
+}
+
+func TestDispatcherRename(t *testing.T) {
+	ingress := backends.NewIngress(1000)
+	a := newTestConf("a", 1, 0)
+	fill(ingress, a, 5, 10)
+	d := newDispatcher(config.APIKeyMap{"key": a}, ingress, 10)
+
+	renamed := newTestConf("b", 1, 0)
+	d.update(config.APIKeyMap{"key": renamed})
+	dispatched := 0
+	for d.round(func(m *backends.Payload, c config.APIKeyConfig) { dispatched++ }) {
+	}
+	if dispatched != 5 {
+		t.Errorf("Batches of a renamed customer should be kept. Got: %d, Expected: 5", dispatched)
+	}
+}
+
+func TestDispatcherBackpressure(t *testing.T) {
+	ingress := backends.NewIngress(1000)
+	a := newTestConf("a", 1, 0)
+	d := backends.NewBackendDest("http://127.0.0.1:8086", 1, 1)
+	a.Dests = map[string]*backends.BackendDest{d.URL: d}
+	fill(ingress, a, 3, 10)
+
+	disp := newDispatcher(config.APIKeyMap{"a": a}, ingress, 100)
+	dispatched := 0
+	for disp.round(func(m *backends.Payload, c config.APIKeyConfig) { d.Enqueue(m); dispatched++ }) {
+	}
+	if dispatched != 1 || !disp.blocked || ingress.Len() != 1 {
+		t.Errorf("Batches should be held back while the out going queue is full. Got: %d dispatched, %d queued", dispatched, ingress.Len())
+	}
+	<-d.Queue
+	if !disp.round(func(m *backends.Payload, c config.APIKeyConfig) { d.Enqueue(m); dispatched++ }) || dispatched != 2 {
+		t.Errorf("Batches should be dispatched once the out going queue has room. Got: %d dispatched", dispatched)
+	}
+}
+
+func TestDispatcherPurgedHead(t *testing.T) {
+	ingress := backends.NewIngress(1000)
+	a := newTestConf("a", 1, 0)
+	d := backends.NewBackendDest("http://127.0.0.1:8086", 1, 1)
+	a.Dests = map[string]*backends.BackendDest{d.URL: d}
+	fill(ingress, a, 2, 10)
+
+	disp := newDispatcher(config.APIKeyMap{"a": a}, ingress, 100)
+	var dispatched []*backends.Payload
+	out := func(m *backends.Payload, c config.APIKeyConfig) { d.Enqueue(m); dispatched = append(dispatched, m) }
+	for disp.round(out) {
+	}
+	// A purge takes the batch held back by the dispatcher.
+	held := ingress.Held(a.IncomingQueue)
+	if len(held) != 1 {
+		t.Fatalf("The dispatcher should hold a batch back. Got: %d", len(held))
+	}
+	<-d.Queue
+	for disp.round(out) {
+	}
+	if len(dispatched) != 1 || dispatched[0] == held[0] {
+		t.Errorf("A purged batch should not be dispatched. Got: %d dispatched", len(dispatched))
+	}
+}
@@ -24,11 +24,16 @@ package writer
 
 import (
 	"bytes"
-	"io/ioutil"
 	"math/rand"
+	"strconv"
 	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/lifecycle"
+	"github.com/samitpal/influxdb-router/stats"
+	"github.com/samitpal/influxdb-router/tracing"
 	"github.com/samitpal/influxdb-router/writer/client"
 )
 
@@ -38,9 +43,60 @@ func random(min, max int) int {
 	return rand.Intn(max-min) + min
 }
 
+// writeInflux writes a batch to a backend and records the latency, the size, the points and the result of the write.
+// The write is traced as a child of the request the batch came with.
+func writeInflux(c client.Writer, message *backends.Payload, conf config.APIKeyConfig, b *backends.BackendDest, retry bool) {
+	url := b.URL
+	span := tracing.StartChild("backend_write", tracing.KindClient, message.Trace)
+	span.SetAttribute("customer", conf.Name)
+	span.SetAttribute("backend", url)
+	span.SetAttribute("retry", strconv.FormatBool(retry))
+
+	b.WriteStarted()
+	start := time.Now()
+	res, err := c.WriteInflux(bytes.NewReader(message.Body), conf.InfluxDBName, message.MessageID, url, span.SpanContext().Traceparent())
+	span.SetAttribute("result", res)
+	if res != client.ResultSuccess {
+		span.SetError(res)
+		lifecycle.Record(message.MessageID, conf.Name, url, lifecycle.WriteFailed, res)
+	} else {
+		lifecycle.Record(message.MessageID, conf.Name, url, lifecycle.Written, "")
+	}
+	span.Finish()
+	latency := time.Since(start)
+	b.WriteFinished(latency, err)
+	log.WithFields(logrus.Fields{
+		"message_id": message.MessageID,
+		"customer":   conf.Name,
+		"backend":    url,
+		"result":     res,
+		"retry":      retry,
+		"points":     message.Points,
+		"bytes":      len(message.Body),
+		"latency_ms": float64(latency.Nanoseconds()) / 1e6,
+	}).Debug("Batch written")
+	tags := stats.Tags{"customer": conf.Name, "backend": url}
+	stats.Observe("backend_write_seconds", tags, latency.Seconds())
+	stats.Default.Observe("backend_write_bytes", tags, stats.SizeBuckets, float64(len(message.Body)))
+	stats.Default.Observe("backend_write_points", tags, stats.PointsBuckets, float64(message.Points))
+	stats.Count("backend_writes", stats.Tags{"customer": conf.Name, "backend": url, "result": res}, 1)
+}
+
+// writeAsync writes a batch in the background. Its bytes stay charged to budget till the write is done,
+// so that the writes in flight to a slow backend count against the memory budget.
+func writeAsync(c client.Writer, message *backends.Payload, conf config.APIKeyConfig, b *backends.BackendDest, retry bool, budget *backends.ByteBudget) {
+	w := startWrite(message, conf.Name, b.URL)
+	go func() {
+		defer budget.Release(len(message.Body))
+		defer finishWrite(w)
+		writeInflux(c, message, conf, b, retry)
+	}()
+}
+
 //InfluxWriter reads from dest queue and writes to the dest.
-func InfluxWriter(b *backends.BackendDest, db string, user string, password string) {
-	c := client.HTTPConfig{URL: b.URL, ContentEncoding: "gzip", Username: user, Password: password}
+func InfluxWriter(b *backends.BackendDest, conf config.APIKeyConfig) {
+	db := conf.InfluxDBName
+	c := client.HTTPConfig{URL: b.URL, ContentEncoding: "gzip", Username: conf.InfluxDBUserName, Password: conf.InfluxDBPassword}
 	p := client.WriteParams{Database: db}
 	httpClient, err := client.NewHTTP(c, p)
 	if err != nil {
@@ -49,25 +105,52 @@ func InfluxWriter(b *backends.BackendDest, db string, user string, password stri
 	}
 
 	// Keep popping messages from the channel and write the same to influxdb in a for loop
-	for message := range b.Queue {
-		body := ioutil.NopCloser(bytes.NewBuffer(message.Body))
+	for {
+		// The queue of a drained backend is kept.
+		if b.Drained() {
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-b.Done():
+				return
+			}
+		}
+		var message *backends.Payload
+		select {
+		case message = <-b.Queue:
+		case <-b.Done():
+			return
+		}
+		span := tracing.StartChildAt("outgoing_queue", tracing.KindInternal, message.Trace, message.Dispatched)
+		span.SetAttribute("customer", conf.Name)
+		span.SetAttribute("backend", b.URL)
 
-		if b.GetHealth() {
-			go httpClient.WriteInflux(body, db, message.MessageID, b.URL)
+		if b.GetHealth() && !b.Drained() {
+			span.Finish()
+			writeAsync(httpClient, message, conf, b, false, b.QueueBytes)
 		} else {
-			log.Infof("Backend:%s is unhealthy. Can't push metrics.", b.URL)
-			select {
-			case b.RetryQueue <- message:
-			default:
+			b.QueueBytes.Release(len(message.Body))
+			reason := "backend unhealthy"
+			if b.Drained() {
+				reason = "backend drained"
+			}
+			span.SetError(reason + ", moved to the retry queue")
+			span.Finish()
+			log.Infof("Backend:%s is unhealthy or drained. Can't push metrics.", b.URL)
+			if !b.EnqueueRetry(message) {
+				DropBatch(message, conf.Name, b.URL, "retry_queue_full")
 				log.Infof("Retry queue for backend:%s might be at capacity.", b.URL)
+			} else {
+				lifecycle.Record(message.MessageID, conf.Name, b.URL, lifecycle.RetryQueued, reason)
 			}
 		}
 	}
 }
 
 // RetryQueueHandler retries messages from the retry queue.
-func RetryQueueHandler(b *backends.BackendDest, db string, user string, password string) {
-	c := client.HTTPConfig{URL: b.URL, ContentEncoding: "gzip", Username: user, Password: password}
+func RetryQueueHandler(b *backends.BackendDest, conf config.APIKeyConfig) {
+	db := conf.InfluxDBName
+	c := client.HTTPConfig{URL: b.URL, ContentEncoding: "gzip", Username: conf.InfluxDBUserName, Password: conf.InfluxDBPassword}
 	p := client.WriteParams{Database: db}
 	httpClient, err := client.NewHTTP(c, p)
 	if err != nil {
@@ -76,18 +159,35 @@ func RetryQueueHandler(b *backends.BackendDest, db string, user string, password
 	}
 
 	for {
-		if len(b.RetryQueue) > 0 {
-			if b.GetHealth() {
-				select {
-				case message := <-b.RetryQueue:
-					body := ioutil.NopCloser(bytes.NewBuffer(message.Body))
-					go httpClient.WriteInflux(body, db, message.MessageID, b.URL)
-				}
-			} else {
-				time.Sleep(time.Duration(random(1, 3)) * time.Second)
+		if len(b.RetryQueue) > 0 && b.GetHealth() && !b.Drained() {
+			select {
+			case message := <-b.RetryQueue:
+				writeAsync(httpClient, message, conf, b, true, b.RetryQueueBytes)
+			case <-b.Done():
+				return
 			}
-		} else {
-			time.Sleep(time.Duration(random(1, 3)) * time.Second)
+			continue
+		}
+		select {
+		case <-time.After(time.Duration(random(1, 3)) * time.Second):
+		case <-b.Flushes():
+			flushRetryQueue(httpClient, b, conf)
+		case <-b.Done():
+			return
+		}
+	}
+}
+
+// flushRetryQueue writes the batches of the retry queue right away, whatever the health of the backend.
+func flushRetryQueue(c client.Writer, b *backends.BackendDest, conf config.APIKeyConfig) {
+	n := len(b.RetryQueue)
+	log.Infof("Flushing %d batches of the retry queue of backend:%s", n, b.URL)
+	for i := 0; i < n; i++ {
+		select {
+		case message := <-b.RetryQueue:
+			writeAsync(c, message, conf, b, true, b.RetryQueueBytes)
+		default:
+			return
 		}
 	}
 }
@@ -0,0 +1,183 @@
+// Package writer provides code for wiring metrics to influxdb
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package writer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/lifecycle"
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+// drainPoll is how often Drain looks at the queues.
+const drainPoll = 100 * time.Millisecond
+
+// inFlightWrite is a write of a batch to a backend.
+type inFlightWrite struct {
+	p        *backends.Payload
+	customer string
+	backend  string
+}
+
+// inFlight are the writes in flight, the ones left at shutdown are recorded as dropped by Spill.
+var inFlight = struct {
+	sync.Mutex
+	writes map[*inFlightWrite]bool
+}{writes: make(map[*inFlightWrite]bool)}
+
+// startWrite adds a write in flight, finishWrite must be called once it is done.
+func startWrite(p *backends.Payload, customer string, backend string) *inFlightWrite {
+	w := &inFlightWrite{p: p, customer: customer, backend: backend}
+	inFlight.Lock()
+	inFlight.writes[w] = true
+	inFlight.Unlock()
+	return w
+}
+
+func finishWrite(w *inFlightWrite) {
+	inFlight.Lock()
+	delete(inFlight.writes, w)
+	inFlight.Unlock()
+}
+
+// Drain waits till the incoming queues are empty, the batches held by the dispatcher are dispatched and
+// the out going and retry queues of the healthy backends are written, or till the deadline. The retry
+// queues of the healthy backends are flushed. It returns true if all the batches were written.
+func Drain(customers *config.Registry, ingress *backends.Ingress, deadline time.Time) bool {
+	// A batch popped from a queue is briefly neither queued nor in flight, so the queues have to be
+	// idle twice in a row.
+	idle := 0
+	for {
+		busy, left := ingress.Len() > 0 || ingress.HeldLen() > 0, false
+		for _, c := range customers.Keys() {
+			for _, d := range c.Dests {
+				s := d.Status()
+				if s.InFlightWrites > 0 {
+					busy = true
+				}
+				if s.QueueLength == 0 && s.RetryQueueLength == 0 {
+					continue
+				}
+				if !s.Healthy || s.Drained {
+					left = true
+					continue
+				}
+				busy = true
+				if s.RetryQueueLength > 0 {
+					d.Flush()
+				}
+			}
+		}
+		if busy {
+			idle = 0
+		} else if idle++; idle == 2 {
+			return !left
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPoll)
+	}
+}
+
+// spillDir returns the directory the batches of a customer, or of a backend of a customer, are spilled to.
+// The escaped names must not be . or .., which url escaping leaves alone, so that it stays under dir.
+func spillDir(dir string, customer string, backend string) (string, error) {
+	names := []string{url.PathEscape(customer)}
+	if backend != "" {
+		names = append(names, url.QueryEscape(backend))
+	}
+	path := dir
+	for _, n := range names {
+		if n == "" || n == "." || n == ".." {
+			return "", fmt.Errorf("can not spill the batches of %q %q to %s", customer, backend, dir)
+		}
+		path = filepath.Join(path, n)
+	}
+	return path, nil
+}
+
+// Spill stops the backends and writes the batches left in the queues to dir, as gzip compressed line
+// protocol files named by message id, and returns their number. The batches of the incoming queues and
+// the ones held by the dispatcher go to dir/<customer>, the ones of a backend to dir/<customer>/<escaped url>.
+// The batches are dropped if dir is empty or can not be written. The writes still in flight, which the exit
+// cuts short, are recorded as dropped. The writer must be stopped.
+//
+// The spilled batches are not replayed by the router on startup, they are kept for the operator to look at
+// or to write to the backends by hand.
+func Spill(dir string, customers *config.Registry, ingress *backends.Ingress) (int, error) {
+	var firstErr error
+	spilled := 0
+	spill := func(p *backends.Payload, customer string, backend string) {
+		if dir == "" {
+			DropBatch(p, customer, backend, "shutdown")
+			return
+		}
+		path, err := spillDir(dir, customer, backend)
+		if err == nil {
+			err = os.MkdirAll(path, 0755)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(path, p.MessageID+".gz"), p.Body, 0644)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			DropBatch(p, customer, backend, "spill_failed")
+			return
+		}
+		spilled++
+		stats.Count("spilled_batches", stats.Tags{"customer": customer}, 1)
+		lifecycle.Record(p.MessageID, customer, backend, lifecycle.Spilled, path)
+	}
+
+	for _, c := range customers.Keys() {
+		for _, p := range ingress.Held(c.IncomingQueue) {
+			spill(p, c.Name, "")
+		}
+		for p := ingress.Pop(c.IncomingQueue); p != nil; p = ingress.Pop(c.IncomingQueue) {
+			spill(p, c.Name, "")
+		}
+		for _, d := range c.Dests {
+			d.Stop()
+			for _, p := range d.Purge() {
+				spill(p, c.Name, d.URL)
+			}
+		}
+	}
+	inFlight.Lock()
+	for w := range inFlight.writes {
+		DropBatch(w.p, w.customer, w.backend, "shutdown_in_flight")
+	}
+	inFlight.Unlock()
+	return spilled, firstErr
+}
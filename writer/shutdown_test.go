@@ -0,0 +1,120 @@
+package writer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/lifecycle"
+)
+
+func TestDrain(t *testing.T) {
+	ingress := backends.NewIngress(10)
+	d := backends.NewBackendDest("http://127.0.0.1:8086", 10, 10)
+	c := config.APIKeyConfig{Name: "a", IncomingQueue: backends.NewIncomingQueue(10, 1, 0), Dests: map[string]*backends.BackendDest{d.URL: d}}
+	customers := config.NewRegistry(&config.Configs{}, config.APIKeyMap{"k": c}, "", false, "")
+
+	// Nothing is queued.
+	start := time.Now()
+	if !Drain(customers, ingress, start.Add(10*time.Second)) || time.Since(start) > time.Second {
+		t.Errorf("Drain should return true right away when nothing is queued. Got it after %v", time.Since(start))
+	}
+
+	// The backend is unhealthy, its batches can not be written.
+	d.EnqueueRetry(&backends.Payload{MessageID: "m1", Body: []byte("one")})
+	start = time.Now()
+	if Drain(customers, ingress, start.Add(10*time.Second)) || time.Since(start) > time.Second {
+		t.Errorf("Drain should return false right away when an unhealthy backend has batches. Got it after %v", time.Since(start))
+	}
+}
+
+func TestSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal, _ := lifecycle.NewJournal(10, "")
+	lifecycle.Default = journal
+	defer func() { lifecycle.Default = nil }()
+
+	ingress := backends.NewIngress(10)
+	d := backends.NewBackendDest("http://127.0.0.1:8086", 10, 10)
+	c := config.APIKeyConfig{Name: "a", IncomingQueue: backends.NewIncomingQueue(10, 1, 0), Dests: map[string]*backends.BackendDest{d.URL: d}}
+	customers := config.NewRegistry(&config.Configs{}, config.APIKeyMap{"k": c}, "", false, "")
+	ingress.Push(c.IncomingQueue, &backends.Payload{MessageID: "m1", Body: []byte("one")})
+	d.EnqueueRetry(&backends.Payload{MessageID: "m2", Body: []byte("two")})
+	w := startWrite(&backends.Payload{MessageID: "m3", Body: []byte("three")}, c.Name, d.URL)
+	defer finishWrite(w)
+
+	n, err := Spill(dir, customers, ingress)
+	if err != nil || n != 2 {
+		t.Fatalf("Wrong number of spilled batches. Got: %d %v, Expected: 2", n, err)
+	}
+	for path, body := range map[string]string{
+		filepath.Join(dir, "a", "m1.gz"):                                  "one",
+		filepath.Join(dir, "a", "http%3A%2F%2F127.0.0.1%3A8086", "m2.gz"): "two",
+	} {
+		if b, err := ioutil.ReadFile(path); err != nil || string(b) != body {
+			t.Errorf("Wrong spilled batch %s. Got: %q %v, Expected: %q", path, b, err, body)
+		}
+	}
+	if ingress.Len() != 0 || len(d.RetryQueue) != 0 {
+		t.Errorf("Queues should be empty after a spill")
+	}
+	if e := journal.Timeline("m3"); len(e) != 1 || e[0].Event != lifecycle.Dropped || e[0].Reason != "shutdown_in_flight" {
+		t.Errorf("The write in flight should be recorded as dropped. Got: %+v", e)
+	}
+}
+
+func TestSpillHeld(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The out going queue is full, the dispatcher holds the next batch back.
+	ingress := backends.NewIngress(10)
+	d := backends.NewBackendDest("http://127.0.0.1:8086", 1, 1)
+	d.SetHealth(true)
+	d.Enqueue(&backends.Payload{MessageID: "m1", Body: []byte("one")})
+	c := config.APIKeyConfig{Name: "a", IncomingQueue: backends.NewIncomingQueue(10, 1, 0), Dests: map[string]*backends.BackendDest{d.URL: d}}
+	customers := config.NewRegistry(&config.Configs{}, config.APIKeyMap{"k": c}, "", false, "")
+	ingress.Push(c.IncomingQueue, &backends.Payload{MessageID: "m2", Body: []byte("two")})
+	dispatcher := newDispatcher(customers.Keys(), ingress, 10)
+	if dispatcher.round(distribute) || !dispatcher.blocked || ingress.Len() != 0 {
+		t.Fatalf("The batch should be held back by the dispatcher")
+	}
+
+	if Drain(customers, ingress, time.Now().Add(300*time.Millisecond)) {
+		t.Errorf("Drain should not return true while the dispatcher holds a batch")
+	}
+	d.Purge()
+	n, err := Spill(dir, customers, ingress)
+	if err != nil || n != 1 {
+		t.Fatalf("Wrong number of spilled batches. Got: %d %v, Expected: 1", n, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "a", "m2.gz")); err != nil || string(b) != "two" {
+		t.Errorf("The held batch should be spilled. Got: %q %v", b, err)
+	}
+	if ingress.HeldLen() != 0 {
+		t.Errorf("Spilled batches should not be held any more. Got: %d", ingress.HeldLen())
+	}
+}
+
+func TestSpillDir(t *testing.T) {
+	dir := filepath.Join("tmp", "spill")
+	if p, err := spillDir(dir, "a/b", "http://127.0.0.1:8086"); err != nil || p != filepath.Join(dir, "a%2Fb", "http%3A%2F%2F127.0.0.1%3A8086") {
+		t.Errorf("Spill directory does not match. Got: %s %v", p, err)
+	}
+	for _, name := range []string{"..", ".", ""} {
+		if p, err := spillDir(dir, name, ""); err == nil {
+			t.Errorf("Customer %q should not be spilled outside its directory. Got: %s", name, p)
+		}
+	}
+}
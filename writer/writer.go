@@ -23,38 +23,151 @@
 package writer
 
 import (
+	"sync"
+	"time"
+
 	"github.com/samitpal/influxdb-router/backends"
 	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/lifecycle"
 	"github.com/samitpal/influxdb-router/logging"
+	"github.com/samitpal/influxdb-router/stats"
+	"github.com/samitpal/influxdb-router/tracing"
 )
 
 var log = logging.For("writer")
 
+// blockedRetry is how long the dispatcher waits for room in the full out going queues.
+const blockedRetry = 10 * time.Millisecond
+
 //OutQueueWriter starts some goroutines and writes the metric streams to the out going queues.
-func OutQueueWriter(apiConf config.APIKeyMap, incomingQueue chan *backends.Payload, ready chan bool) {
+// It follows the changes of the customers, starting and stopping the writers of their backends.
+// It returns once stop is closed and the batches it dispatched are in the out going queues.
+func OutQueueWriter(customers *config.Registry, ingress *backends.Ingress, ready chan bool, stop <-chan struct{}) {
+	apiConf := customers.Keys()
+	version := customers.Version()
 	for _, c := range apiConf {
 		// start a goroutine for each of the out going queues.
 		for _, d := range c.Dests {
-			go InfluxWriter(d, c.InfluxDBName, c.InfluxDBUserName, c.InfluxDBPassword)
-			go RetryQueueHandler(d, c.InfluxDBName, c.InfluxDBUserName, c.InfluxDBPassword)
-			// Note that this will start multiple health check goroutines for diff customer even if the URL is same.
-			go d.HealthCheck()
+			StartDest(d, c)
 		}
 	}
 
 	ready <- true
 
-	// pop messages from the incoming queue and distribute to the relevant out going queues.
-	for messages := range incomingQueue {
-		conf := apiConf[messages.APIKey]
-		for _, v := range conf.Dests {
-			go func(m *backends.Payload, d *backends.BackendDest) {
-				select {
-				case d.Queue <- m:
-				default:
-					log.Errorf("Error copying messages to outgoing queue of dest %s", d.URL)
+	// pop messages from the incoming queues and distribute to the relevant out going queues.
+	dispatcher := newDispatcher(apiConf, ingress, defaultQuantum)
+	for {
+		select {
+		case <-stop:
+			distributing.Wait()
+			return
+		default:
+		}
+		changed := customers.Changed()
+		if v := customers.Version(); v != version {
+			next := customers.Keys()
+			updateDests(apiConf, next)
+			dispatcher.update(next)
+			apiConf, version = next, v
+		}
+		if !dispatcher.round(distribute) {
+			// The out going queues do not signal when they have room again.
+			var retry <-chan time.Time
+			if dispatcher.blocked {
+				retry = time.After(blockedRetry)
+			}
+			select {
+			case <-ingress.Ready():
+			case <-changed:
+			case <-stop:
+			case <-retry:
+			}
+		}
+	}
+}
+
+// updateDests stops the backends of the customers that were removed or changed and starts the new ones.
+// The batches queued to a stopped backend are moved to the new backend with the same url of the
+// customer, found with config.APIKeyMap.Successor, if any, otherwise they are dropped.
+func updateDests(old config.APIKeyMap, next config.APIKeyMap) {
+	running := make(map[*backends.BackendDest]bool)
+	for _, c := range old {
+		for _, d := range c.Dests {
+			running[d] = true
+		}
+	}
+	current := make(map[*backends.BackendDest]bool)
+	for _, c := range next {
+		for _, d := range c.Dests {
+			current[d] = true
+		}
+	}
+
+	for k, c := range old {
+		s, found := next.Successor(k, c.Name)
+		for _, d := range c.Dests {
+			if current[d] {
+				continue
+			}
+			d.Stop()
+			if n, ok := s.Dests[config.BackendKey(d.URL)]; found && ok && !running[n] {
+				for _, p := range n.TakeOver(d) {
+					DropBatch(p, s.Name, d.URL, "outgoing_queue_full")
 				}
-			}(messages, v)
+				continue
+			}
+			for _, p := range d.Purge() {
+				DropBatch(p, c.Name, d.URL, "backend_removed")
+			}
+		}
+	}
+	for _, c := range next {
+		for _, d := range c.Dests {
+			if !running[d] {
+				StartDest(d, c)
+			}
 		}
 	}
 }
+
+// DropBatch counts and records a batch that is dropped. The backend is empty for the incoming queues.
+func DropBatch(p *backends.Payload, customer string, backend string, reason string) {
+	tags := stats.Tags{"customer": customer, "reason": reason}
+	if backend != "" {
+		tags["backend"] = backend
+	}
+	stats.Count("dropped_batches", tags, 1)
+	lifecycle.Record(p.MessageID, customer, backend, lifecycle.Dropped, reason)
+}
+
+// StartDest starts the goroutines writing the out going and retry queues of a backend and checking its health.
+func StartDest(d *backends.BackendDest, c config.APIKeyConfig) {
+	go InfluxWriter(d, c)
+	go RetryQueueHandler(d, c)
+	// Note that this will start multiple health check goroutines for diff customer even if the URL is same.
+	go d.HealthCheck()
+}
+
+// distributing are the batches being copied to the out going queues.
+var distributing sync.WaitGroup
+
+// distribute copies a batch to the out going queues of all the backends of the customer.
+func distribute(m *backends.Payload, conf config.APIKeyConfig) {
+	m.Dispatched = time.Now()
+	span := tracing.StartChildAt("incoming_queue", tracing.KindInternal, m.Trace, m.Received)
+	span.SetAttribute("customer", conf.Name)
+	span.FinishAt(m.Dispatched)
+
+	for _, v := range conf.Dests {
+		distributing.Add(1)
+		go func(m *backends.Payload, d *backends.BackendDest) {
+			defer distributing.Done()
+			if !d.Enqueue(m) {
+				DropBatch(m, conf.Name, d.URL, "outgoing_queue_full")
+				log.Errorf("Error copying messages to outgoing queue of dest %s", d.URL)
+				return
+			}
+			lifecycle.Record(m.MessageID, conf.Name, d.URL, lifecycle.Queued, "")
+		}(m, v)
+	}
+}
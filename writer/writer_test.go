@@ -0,0 +1,44 @@
+package writer
+
+import (
+	"testing"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+func TestUpdateDestsKeepsBatches(t *testing.T) {
+	key, name, db, hosts := "5ca1ab1e", "a", "telegraf", []string{"http://127.0.0.1:1"}
+	customers := config.NewRegistry(&config.Configs{}, config.APIKeyMap{}, "", false, "")
+	if err := customers.Create(config.Config{APIKey: &key, Name: &name, InfluxDBName: &db, InfluxHosts: &hosts}); err != nil {
+		t.Fatal(err)
+	}
+	old := customers.Keys()
+	d := old[key].Dests[config.BackendKey(hosts[0])]
+	// The writers of a drained backend leave its queues alone.
+	d.SetDrained(true)
+	d.Enqueue(&backends.Payload{MessageID: "queued", Body: []byte("cpu")})
+	d.EnqueueRetry(&backends.Payload{MessageID: "retried", Body: []byte("mem")})
+
+	db2 := "telegraf2"
+	if err := customers.Update(name, config.Config{Name: &name, InfluxDBName: &db2, InfluxHosts: &hosts}); err != nil {
+		t.Fatal(err)
+	}
+	next := customers.Keys()
+	updateDests(old, next)
+	n := next[key].Dests[config.BackendKey(hosts[0])]
+	defer n.Stop()
+
+	if n == d {
+		t.Fatalf("The update should replace the backend")
+	}
+	if len(n.Queue) != 1 || len(n.RetryQueue) != 1 {
+		t.Errorf("Batches should survive the update. Got: %d queued, %d to retry, Expected: 1, 1", len(n.Queue), len(n.RetryQueue))
+	}
+	if !n.Drained() {
+		t.Errorf("Drain mode should survive the update")
+	}
+	if len(d.Queue) != 0 || len(d.RetryQueue) != 0 {
+		t.Errorf("Old backend should be empty. Got: %d, %d", len(d.Queue), len(d.RetryQueue))
+	}
+}